@@ -0,0 +1,189 @@
+package animation
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Format is the file format of the animation.
+type Format int
+
+const (
+	// GIF quantizes the frames to a palette of at most 256 colors with Floyd-Steinberg dithering. The frames are kept in
+	// memory until the encoder is closed.
+	GIF Format = iota
+	// APNG keeps the frames in full color, streaming them to the file as they are added.
+	APNG
+)
+
+// FormatOf returns the format for the file extension, .gif or .png/.apng.
+func FormatOf(filename string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gif":
+		return GIF, nil
+	case ".png", ".apng":
+		return APNG, nil
+	}
+	return 0, fmt.Errorf("unknown animation format of %v", filename)
+}
+
+// Options represents options to encode an animation.
+type Options struct {
+	OutputFile string
+	Format     Format
+	// Frames per second, defaults to 20.
+	FrameRate float64
+	// Number of times the animation is played, 0 to loop forever.
+	Loops int
+	// Colors the GIF frames are quantized to, e.g., the spectrum from heatmap.Load which is resampled to leave room
+	// for a few fixed colors of annotations. Defaults to the Plan 9 palette.
+	Palette []color.Color
+}
+
+// Encoder writes the frames added to it into an animation file.
+type Encoder struct {
+	opts Options
+	// Frame delay in 1/100s for GIF, and the paletted frames kept until Close.
+	delay   int
+	palette color.Palette
+	gif     *gif.GIF
+	// APNG frames are written as they are added.
+	apng *apngWriter
+	// Size of the first frame, which all frames must share.
+	bounds image.Rectangle
+	frames int
+}
+
+// NewEncoder creates the animation file.
+func NewEncoder(opts Options) (*Encoder, error) {
+	if opts.FrameRate < 0.0 || opts.Loops < 0 {
+		return nil, fmt.Errorf("invalid frame rate %v or loop count %v", opts.FrameRate, opts.Loops)
+	}
+	if opts.FrameRate == 0.0 {
+		opts.FrameRate = 20.0
+	}
+	e := &Encoder{opts: opts}
+	switch opts.Format {
+	case GIF:
+		e.delay = int(math.Round(100.0 / opts.FrameRate))
+		e.palette = gifPalette(opts.Palette)
+		// GIF counts the repeats after the first play, with -1 to play once and 0 to loop forever.
+		loops := opts.Loops - 1
+		if opts.Loops == 0 {
+			loops = 0
+		}
+		e.gif = &gif.GIF{LoopCount: loops}
+		// Fail early rather than after rendering all frames.
+		f, err := os.Create(opts.OutputFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create output file '%v': %v", opts.OutputFile, err)
+		}
+		if err := f.Close(); err != nil {
+			return nil, fmt.Errorf("failed to create output file '%v': %v", opts.OutputFile, err)
+		}
+	case APNG:
+		w, err := newAPNGWriter(opts.OutputFile, opts.FrameRate, opts.Loops)
+		if err != nil {
+			return nil, err
+		}
+		e.apng = w
+	default:
+		return nil, fmt.Errorf("invalid animation format %v", opts.Format)
+	}
+	return e, nil
+}
+
+// Add appends the frame to the animation.
+func (e *Encoder) Add(img image.Image) error {
+	b := img.Bounds()
+	if e.frames == 0 {
+		e.bounds = b
+	} else if b.Size() != e.bounds.Size() {
+		return fmt.Errorf("frame %v is %v, expected %v", e.frames, b.Size(), e.bounds.Size())
+	}
+	e.frames++
+	if e.apng != nil {
+		return e.apng.add(img)
+	}
+	pm := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), e.palette)
+	draw.FloydSteinberg.Draw(pm, pm.Bounds(), img, b.Min)
+	e.gif.Image = append(e.gif.Image, pm)
+	e.gif.Delay = append(e.gif.Delay, e.delay)
+	return nil
+}
+
+// Close finishes writing the animation file.
+func (e *Encoder) Close() error {
+	if e.frames == 0 {
+		return fmt.Errorf("no frames added to %v", e.opts.OutputFile)
+	}
+	if e.apng != nil {
+		return e.apng.close()
+	}
+	f, err := os.Create(e.opts.OutputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create output file '%v': %v", e.opts.OutputFile, err)
+	}
+	if err := gif.EncodeAll(f, e.gif); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to encode to GIF: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close '%v': %v", e.opts.OutputFile, err)
+	}
+	return nil
+}
+
+// Abort closes and removes the unfinished animation file, e.g., after rendering its frames failed.
+func (e *Encoder) Abort() error {
+	if e.apng != nil {
+		e.apng.f.Close()
+	}
+	if err := os.Remove(e.opts.OutputFile); err != nil {
+		return fmt.Errorf("failed to remove '%v': %v", e.opts.OutputFile, err)
+	}
+	return nil
+}
+
+// gifPalette resamples the spectrum evenly and adds black, white, grays and saturated colors for annotations drawn
+// over the frames.
+func gifPalette(spectrum []color.Color) color.Palette {
+	if len(spectrum) == 0 {
+		return palette.Plan9
+	}
+	var fixed color.Palette
+	for i := 0; i < 8; i++ {
+		g := uint8(i * 255 / 7)
+		fixed = append(fixed, color.RGBA{g, g, g, 0xff})
+	}
+	levels := []uint8{0, 0x80, 0xff}
+	for _, r := range levels {
+		for _, g := range levels {
+			for _, b := range levels {
+				if r == g && g == b {
+					// Grays are already in.
+					continue
+				}
+				fixed = append(fixed, color.RGBA{r, g, b, 0xff})
+			}
+		}
+	}
+	n := min(len(spectrum), 256-len(fixed))
+	p := make(color.Palette, 0, n+len(fixed))
+	for i := 0; i < n; i++ {
+		k := 0
+		if n > 1 {
+			k = i * (len(spectrum) - 1) / (n - 1)
+		}
+		p = append(p, spectrum[k])
+	}
+	return append(p, fixed...)
+}
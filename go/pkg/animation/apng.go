@@ -0,0 +1,202 @@
+package animation
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// apngWriter streams the frames into an APNG file as 8-bit straight alpha RGBA, patching the frame count in the acTL chunk on close.
+type apngWriter struct {
+	f        *os.File
+	filename string
+	// Frame delay as the fraction num/den seconds.
+	delayNum, delayDen uint16
+	loops              int
+	// Offset of the acTL chunk, and the sequence number of the next fcTL or fdAT chunk.
+	actlOffset int64
+	seq        uint32
+	frames     int
+	// Frame converted to straight alpha, which PNG stores unlike the premultiplied image.RGBA.
+	nrgba *image.NRGBA
+}
+
+func newAPNGWriter(filename string, frameRate float64, loops int) (*apngWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file '%v': %v", filename, err)
+	}
+	// Delay of 1/frameRate seconds in milliseconds, which is precise enough for any sensible frame rate.
+	delay := math.Round(1000.0 / frameRate)
+	if delay > math.MaxUint16 {
+		f.Close()
+		return nil, fmt.Errorf("invalid frame rate %v", frameRate)
+	}
+	return &apngWriter{f: f, filename: filename, delayNum: uint16(delay), delayDen: 1000, loops: loops}, nil
+}
+
+func (w *apngWriter) add(img image.Image) error {
+	b := img.Bounds()
+	if w.frames == 0 {
+		w.nrgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		if err := w.writeHeader(b.Dx(), b.Dy()); err != nil {
+			return err
+		}
+	}
+	draw.Draw(w.nrgba, w.nrgba.Bounds(), img, b.Min, draw.Src)
+
+	fctl := make([]byte, 26)
+	binary.BigEndian.PutUint32(fctl[0:], w.seq)
+	binary.BigEndian.PutUint32(fctl[4:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(fctl[8:], uint32(b.Dy()))
+	// Zero x and y offsets.
+	binary.BigEndian.PutUint16(fctl[20:], w.delayNum)
+	binary.BigEndian.PutUint16(fctl[22:], w.delayDen)
+	// Dispose op none, blend op source since each frame replaces the whole canvas.
+	fctl[24], fctl[25] = 0, 0
+	w.seq++
+	if err := w.chunk("fcTL", fctl); err != nil {
+		return err
+	}
+
+	z, err := compress(w.nrgba)
+	if err != nil {
+		return err
+	}
+	if w.frames == 0 {
+		// The first frame doubles as the default image shown by decoders not supporting APNG.
+		if err := w.chunk("IDAT", z); err != nil {
+			return err
+		}
+	} else {
+		fdat := make([]byte, 4+len(z))
+		binary.BigEndian.PutUint32(fdat, w.seq)
+		copy(fdat[4:], z)
+		w.seq++
+		if err := w.chunk("fdAT", fdat); err != nil {
+			return err
+		}
+	}
+	w.frames++
+	return nil
+}
+
+func (w *apngWriter) close() error {
+	if err := w.finish(); err != nil {
+		w.f.Close()
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("failed to close '%v': %v", w.filename, err)
+	}
+	return nil
+}
+
+// finish ends the file and patches the frame count.
+func (w *apngWriter) finish() error {
+	if err := w.chunk("IEND", nil); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(w.actlOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek in '%v': %v", w.filename, err)
+	}
+	return w.chunk("acTL", w.actl())
+}
+
+func (w *apngWriter) writeHeader(width, height int) error {
+	if _, err := w.f.Write(pngSignature); err != nil {
+		return fmt.Errorf("failed to write '%v': %v", w.filename, err)
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(height))
+	// 8-bit RGBA, deflate, adaptive filtering, no interlace.
+	ihdr[8], ihdr[9] = 8, 6
+	if err := w.chunk("IHDR", ihdr); err != nil {
+		return err
+	}
+	// The frame count is unknown until close, so write a placeholder to be patched.
+	offset, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to seek in '%v': %v", w.filename, err)
+	}
+	w.actlOffset = offset
+	return w.chunk("acTL", w.actl())
+}
+
+func (w *apngWriter) actl() []byte {
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl[0:], uint32(w.frames))
+	binary.BigEndian.PutUint32(actl[4:], uint32(w.loops))
+	return actl
+}
+
+func (w *apngWriter) chunk(name string, data []byte) error {
+	buf := make([]byte, 8+len(data)+4)
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(data)))
+	copy(buf[4:], name)
+	copy(buf[8:], data)
+	binary.BigEndian.PutUint32(buf[8+len(data):], crc32.ChecksumIEEE(buf[4:8+len(data)]))
+	if _, err := w.f.Write(buf); err != nil {
+		return fmt.Errorf("failed to write '%v': %v", w.filename, err)
+	}
+	return nil
+}
+
+// compress filters each scanline with the Paeth predictor and deflates the image.
+func compress(img *image.NRGBA) ([]byte, error) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	stride := width * 4
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	line := make([]byte, 1+stride)
+	line[0] = 4
+	prev := make([]byte, stride)
+	for y := 0; y < height; y++ {
+		cur := img.Pix[y*img.Stride : y*img.Stride+stride]
+		for i := 0; i < stride; i++ {
+			var a, c byte
+			if i >= 4 {
+				a, c = cur[i-4], prev[i-4]
+			}
+			line[1+i] = cur[i] - paeth(a, prev[i], c)
+		}
+		if _, err := zw.Write(line); err != nil {
+			return nil, fmt.Errorf("failed to compress frame: %v", err)
+		}
+		prev = cur
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress frame: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// paeth returns whichever of the left, up and upper left neighbors is closest to left+up-upper left.
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,183 @@
+package colorbar
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// Side is the side of the image the color bar is drawn along.
+type Side int
+
+const (
+	Right Side = iota
+	Left
+	Bottom
+	Top
+)
+
+// Options represents options to draw a color bar legend of a heatmap.
+type Options struct {
+	// Spectrum of the heatmap from low to high, e.g., from heatmap.Load.
+	Heatmap []color.Color
+	// Values at the low and high ends of the spectrum.
+	Min  float64
+	Max  float64
+	Side Side
+	// Title drawn past the high end of the bar if not empty.
+	Label string
+	// Approximate number of ticks, defaults to 5.
+	Ticks int
+	// Length of the bar as a fraction of the image side, defaults to 0.5.
+	Length float64
+	// Thickness of the bar and its distance to the side of the image in pixels, default to 16 and 12.
+	Thickness float64
+	Margin    float64
+	// Color of the frame, ticks and labels, defaults to white.
+	Color color.Color
+}
+
+// Length of the tick marks in pixels.
+const tickLength = 4.0
+
+// Draw draws the color bar over the image.
+func Draw(img draw.Image, opts Options) error {
+	if len(opts.Heatmap) == 0 {
+		return fmt.Errorf("empty heatmap")
+	}
+	if !(opts.Max > opts.Min) {
+		return fmt.Errorf("invalid color bar range [%v,%v]", opts.Min, opts.Max)
+	}
+	if opts.Side < Right || opts.Side > Top {
+		return fmt.Errorf("invalid color bar side %v", opts.Side)
+	}
+	if opts.Length == 0.0 {
+		opts.Length = 0.5
+	}
+	if opts.Thickness == 0.0 {
+		opts.Thickness = 16.0
+	}
+	if opts.Margin == 0.0 {
+		opts.Margin = 12.0
+	}
+	if opts.Color == nil {
+		opts.Color = color.White
+	}
+
+	// The bar is drawn on a transparent layer composited over the image, so that any draw.Image works.
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	dc := gg.NewContext(b.Dx(), b.Dy())
+	vertical := opts.Side == Right || opts.Side == Left
+	// (x0,y0) is the corner of the bar at the low end, (ux,uy) the direction towards the high end, and (nx,ny) the
+	// direction from the bar towards the labels.
+	var x0, y0, ux, uy, nx, ny, length float64
+	switch opts.Side {
+	case Right:
+		length = opts.Length * h
+		x0, y0, ux, uy, nx, ny = w-opts.Margin-opts.Thickness, (h+length)/2, 0, -1, -1, 0
+	case Left:
+		length = opts.Length * h
+		x0, y0, ux, uy, nx, ny = opts.Margin, (h+length)/2, 0, -1, 1, 0
+	case Bottom:
+		length = opts.Length * w
+		x0, y0, ux, uy, nx, ny = (w-length)/2, h-opts.Margin-opts.Thickness, 1, 0, 0, -1
+	case Top:
+		length = opts.Length * w
+		x0, y0, ux, uy, nx, ny = (w-length)/2, opts.Margin, 1, 0, 0, 1
+	}
+	// rect returns the rectangle of the bar between s0 and s1 along its length.
+	rect := func(s0, s1 float64) (float64, float64, float64, float64) {
+		if vertical {
+			return x0, y0 - s1, opts.Thickness, s1 - s0
+		}
+		return x0 + s0, y0, s1 - s0, opts.Thickness
+	}
+
+	hm := opts.Heatmap
+	for i := 0; i < int(math.Ceil(length)); i++ {
+		t := (float64(i) + 0.5) / length
+		dc.SetColor(hm[int(math.Min(t, 1.0)*float64(len(hm)-1))])
+		dc.DrawRectangle(rect(float64(i), math.Min(float64(i+1), length)))
+		dc.Fill()
+	}
+	dc.SetColor(opts.Color)
+	dc.SetLineWidth(1)
+	dc.DrawRectangle(rect(0, length))
+	dc.Stroke()
+
+	// The side of the bar facing the labels, and the anchor of the labels on it.
+	var ex, ey, ax, ay float64
+	switch opts.Side {
+	case Right:
+		ex, ax, ay = x0, 1, 0.5
+	case Left:
+		ex, ax, ay = x0+opts.Thickness, 0, 0.5
+	case Bottom:
+		ey, ax, ay = y0, 0.5, 0
+	case Top:
+		ey, ax, ay = y0+opts.Thickness, 0.5, 1
+	}
+	ticks, step := Ticks(opts.Min, opts.Max, opts.Ticks)
+	for _, v := range ticks {
+		s := (v - opts.Min) / (opts.Max - opts.Min) * length
+		px, py := x0+ux*s, y0+uy*s
+		if vertical {
+			px = ex
+		} else {
+			py = ey
+		}
+		dc.DrawLine(px, py, px+nx*tickLength, py+ny*tickLength)
+		dc.Stroke()
+		dc.DrawStringAnchored(TickLabel(v, step), px+nx*(tickLength+2), py+ny*(tickLength+2), ax, ay)
+	}
+	if opts.Label != "" {
+		// Past the high end of the bar, aligned with its edge nearest to the side of the image so it is not clipped.
+		cx, cy := x0+ux*length, y0+uy*length
+		switch opts.Side {
+		case Right:
+			dc.DrawStringAnchored(opts.Label, cx+opts.Thickness, cy-6, 1, 0)
+		case Left:
+			dc.DrawStringAnchored(opts.Label, cx, cy-6, 0, 0)
+		default:
+			dc.DrawStringAnchored(opts.Label, cx+6, cy+opts.Thickness/2, 0, 0.5)
+		}
+	}
+	draw.Draw(img, b, dc.Image(), image.Point{}, draw.Over)
+	return nil
+}
+
+// Ticks returns the multiples of a round step (1, 2 or 5 times a power of 10) strictly inside (min, max), about n of
+// them, and the step. n defaults to 5.
+func Ticks(min, max float64, n int) ([]float64, float64) {
+	if n <= 0 {
+		n = 5
+	}
+	raw := (max - min) / float64(n)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	step := 10 * mag
+	for _, f := range []float64{1, 2, 5} {
+		if f*mag >= raw {
+			step = f * mag
+			break
+		}
+	}
+	var ret []float64
+	for k := math.Floor(min/step) + 1; k*step < max; k++ {
+		ret = append(ret, k*step)
+	}
+	return ret, step
+}
+
+// TickLabel formats the tick value with as many decimals as the step needs.
+func TickLabel(v, step float64) string {
+	decimals := int(math.Max(0, -math.Floor(math.Log10(step))))
+	if math.Abs(v) < step/2 {
+		v = 0
+	}
+	return fmt.Sprintf("%.*f", decimals, v)
+}
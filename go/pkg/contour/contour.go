@@ -0,0 +1,148 @@
+package contour
+
+import "math"
+
+// Levels returns n levels evenly spaced strictly inside (min, max).
+func Levels(min, max float64, n int) []float64 {
+	levels := make([]float64, n)
+	for i := range levels {
+		levels[i] = min + (max-min)*float64(i+1)/float64(n+1)
+	}
+	return levels
+}
+
+// Lines extracts the contour lines at the given level from the row-major grid of w x h samples by marching squares.
+// Points are in grid coordinates, i.e., (i,j) is the location of data[j*w+i]. Cells with a NaN corner are skipped.
+// Closed contours repeat their first point at the end.
+func Lines(data []float64, w, h int, level float64) [][][2]float64 {
+	// Edges are identified by their lower-left grid point and orientation, the crossing point is found by linear
+	// interpolation along the edge.
+	type edge struct {
+		i, j     int
+		vertical bool
+	}
+	crossing := func(e edge) [2]float64 {
+		a := data[e.j*w+e.i]
+		var b float64
+		if e.vertical {
+			b = data[(e.j+1)*w+e.i]
+		} else {
+			b = data[e.j*w+e.i+1]
+		}
+		t := (level - a) / (b - a)
+		if e.vertical {
+			return [2]float64{float64(e.i), float64(e.j) + t}
+		}
+		return [2]float64{float64(e.i) + t, float64(e.j)}
+	}
+
+	// Collect the segments, each connecting two edges, and which segments touch each edge.
+	var segs [][2]edge
+	touching := map[edge][]int{}
+	add := func(a, b edge) {
+		touching[a] = append(touching[a], len(segs))
+		touching[b] = append(touching[b], len(segs))
+		segs = append(segs, [2]edge{a, b})
+	}
+	for j := 0; j+1 < h; j++ {
+		for i := 0; i+1 < w; i++ {
+			v00, v10 := data[j*w+i], data[j*w+i+1]
+			v01, v11 := data[(j+1)*w+i], data[(j+1)*w+i+1]
+			if math.IsNaN(v00) || math.IsNaN(v10) || math.IsNaN(v01) || math.IsNaN(v11) {
+				continue
+			}
+			// Corners above the level, counterclockwise from (i,j).
+			c := 0
+			for k, v := range []float64{v00, v10, v11, v01} {
+				if v > level {
+					c |= 1 << k
+				}
+			}
+			bottom := edge{i, j, false}
+			right := edge{i + 1, j, true}
+			top := edge{i, j + 1, false}
+			left := edge{i, j, true}
+			switch c {
+			case 1, 14:
+				add(left, bottom)
+			case 2, 13:
+				add(bottom, right)
+			case 3, 12:
+				add(left, right)
+			case 4, 11:
+				add(right, top)
+			case 6, 9:
+				add(bottom, top)
+			case 7, 8:
+				add(left, top)
+			case 5, 10:
+				// Saddle, resolved by the average of the corners.
+				center := (v00 + v10 + v01 + v11) / 4.0
+				if (center > level) == (c == 5) {
+					add(left, top)
+					add(bottom, right)
+				} else {
+					add(left, bottom)
+					add(right, top)
+				}
+			}
+		}
+	}
+
+	// Chain the segments into polylines.
+	used := make([]bool, len(segs))
+	// next returns an unused segment touching the edge.
+	next := func(e edge) (int, bool) {
+		for _, s := range touching[e] {
+			if !used[s] {
+				return s, true
+			}
+		}
+		return 0, false
+	}
+	var lines [][][2]float64
+	for s := range segs {
+		if used[s] {
+			continue
+		}
+		used[s] = true
+		// Walk forward from the second edge, then backward from the first.
+		fwd := []edge{segs[s][0], segs[s][1]}
+		for {
+			last := fwd[len(fwd)-1]
+			n, ok := next(last)
+			if !ok {
+				break
+			}
+			used[n] = true
+			if segs[n][0] == last {
+				fwd = append(fwd, segs[n][1])
+			} else {
+				fwd = append(fwd, segs[n][0])
+			}
+		}
+		var bwd []edge
+		for first := fwd[0]; ; {
+			n, ok := next(first)
+			if !ok {
+				break
+			}
+			used[n] = true
+			if segs[n][0] == first {
+				first = segs[n][1]
+			} else {
+				first = segs[n][0]
+			}
+			bwd = append(bwd, first)
+		}
+		line := make([][2]float64, 0, len(bwd)+len(fwd))
+		for k := len(bwd) - 1; k >= 0; k-- {
+			line = append(line, crossing(bwd[k]))
+		}
+		for _, e := range fwd {
+			line = append(line, crossing(e))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,97 @@
+package fieldline
+
+import "math"
+
+// arrow is an arrowhead projected on the screen.
+type arrow struct {
+	x, y float64
+	// Unit direction on the screen.
+	dx, dy        float64
+	tangentLength float64
+	scalar        float64
+	// Distance in front of the camera plane.
+	depth float64
+}
+
+// arrowStops returns the interpolated points and field directions along the line at every spacing in arc length,
+// starting half a spacing from the beginning of the line.
+func arrowStops(line []tracePoint, spacing float64) ([]tracePoint, []Vec3) {
+	var stops []tracePoint
+	var dir []Vec3
+	lerp := func(a, b, f float64) float64 { return a + (b-a)*f }
+	next := spacing / 2.0
+	walked := 0.0
+	for i := 0; i+1 < len(line); i++ {
+		d := line[i+1].pos.Subtract(line[i].pos)
+		l := d.Norm()
+		if l == 0.0 {
+			continue
+		}
+		for walked+l >= next {
+			f := (next - walked) / l
+			stops = append(stops, tracePoint{
+				pos:           line[i].pos.Add(d.Scale(f)),
+				tangentLength: lerp(line[i].tangentLength, line[i+1].tangentLength, f),
+				scalar:        lerp(line[i].scalar, line[i+1].scalar, f),
+			})
+			dir = append(dir, d.Scale(1.0/l))
+			next += spacing
+		}
+		walked += l
+	}
+	return stops, dir
+}
+
+// projectArrows projects the arrowheads of the trajectory for every camera and symmetry, dropping those off screen
+// or hidden by occluders.
+func (traj *Trajectory) projectArrows(opts *Options) {
+	stops, dir := arrowStops(traj.line, opts.ArrowSpacing)
+	// World space offset used to find the direction on the screen.
+	eps := opts.ArrowSpacing * 1e-3
+	traj.arrows = make([][]arrow, len(opts.cameras)*len(traj.symmetries))
+	for c, camera := range opts.cameras {
+		for s, sym := range traj.symmetries {
+			idx := c*len(traj.symmetries) + s
+			for k, stop := range stops {
+				p := stop.pos
+				tp := sym.transform(p)
+				px := camera.worldToScreen(tp, opts.Width, opts.Height)
+				if !px.inBound(opts.Width, opts.Height) ||
+					len(opts.Occluders) > 0 && camera.hidden(tp, opts.Occluders) {
+					continue
+				}
+				x0, y0 := camera.project(tp, opts.Width, opts.Height)
+				x1, y1 := camera.project(sym.transform(p.Add(dir[k].Scale(eps))), opts.Width, opts.Height)
+				dx, dy := x1-x0, y1-y0
+				l := math.Hypot(dx, dy)
+				if l == 0.0 {
+					// Pointing at the camera.
+					continue
+				}
+				traj.arrows[idx] = append(traj.arrows[idx], arrow{
+					x:             x0,
+					y:             y0,
+					dx:            dx / l,
+					dy:            dy / l,
+					tangentLength: stop.tangentLength,
+					scalar:        stop.scalar,
+					depth:         camera.depth(tp),
+				})
+			}
+		}
+	}
+}
+
+// draw fills the arrowhead centered on its position, size pixels from the tip to the base.
+func (a arrow) draw(cv canvas, size float64, color [3]float64, alpha float64) {
+	// Normal on the screen.
+	nx, ny := -a.dy, a.dx
+	h := size / 2.0
+	w := size * 0.35
+	tri := [][2]float64{
+		{a.x + a.dx*h, a.y + a.dy*h},
+		{a.x - a.dx*h + nx*w, a.y - a.dy*h + ny*w},
+		{a.x - a.dx*h - nx*w, a.y - a.dy*h - ny*w},
+	}
+	cv.shape([][][2]float64{tri}, true, Style{Fill: color, FillAlpha: alpha})
+}
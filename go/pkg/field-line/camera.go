@@ -1,21 +1,78 @@
 package fieldline
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
 
 type camera struct {
 	pos Vec3
-	// Normalized screen frame in world coordinate.
+	// Normalized screen frame in world coordinate, the camera looks along -sz.
 	sx Vec3
 	sy Vec3
 	sz Vec3
+	// tan of half the field of view for perspective projection, orthographic if zero.
+	tanHalfFOV float64
+	// Magnification and screen center offset, in unzoomed screen units.
+	zoom float64
+	pan  [2]float64
 }
 
-func (cam *camera) worldToScreen(p Vec3, w, h int) pixel {
+// Perspective projections ignore points closer than this in front of the camera, and all points behind it.
+const nearPlane = 1e-6
+
+// Bound on the screen coordinates, many times the visible [-1,1].
+const maxScreen = 1e4
+
+// screen maps p to the screen coordinates in [-1,1] across the visible area, with y pointing up.
+// Returns false if p is behind a perspective camera.
+func (cam *camera) screen(p Vec3) (float64, float64, bool) {
 	q := p.Subtract(cam.pos)
+	u, v := q.Dot(cam.sx), q.Dot(cam.sy)
+	if cam.tanHalfFOV > 0.0 {
+		d := -q.Dot(cam.sz)
+		if d < nearPlane {
+			return 0.0, 0.0, false
+		}
+		u, v = u/(d*cam.tanHalfFOV), v/(d*cam.tanHalfFOV)
+	}
+	// Clamp far off screen points so that they stay representable as pixels.
+	clamp := func(x float64) float64 { return math.Max(-maxScreen, math.Min(maxScreen, x)) }
+	return clamp(cam.zoom * (u - cam.pan[0])), clamp(cam.zoom * (v - cam.pan[1])), true
+}
+
+// depth returns the distance of p in front of the camera plane.
+func (cam *camera) depth(p Vec3) float64 {
+	return -p.Subtract(cam.pos).Dot(cam.sz)
+}
+
+func (cam *camera) worldToScreen(p Vec3, w, h int) pixel {
+	u, v, ok := cam.screen(p)
+	if !ok {
+		return pixel{-1, -1}
+	}
 	return pixel{
-		w/2 + int(float64(w)*q.Dot(cam.sx)/2.0),
-		h/2 - int(float64(h)*q.Dot(cam.sy)/2.0),
+		w/2 + int(float64(w)*u/2.0),
+		h/2 - int(float64(h)*v/2.0),
+	}
+}
+
+// project is like worldToScreen but without rounding to pixels. Points behind the camera land far off screen.
+func (cam *camera) project(p Vec3, w, h int) (float64, float64) {
+	u, v, ok := cam.screen(p)
+	if !ok {
+		return -float64(w), -float64(h)
 	}
+	return float64(w)/2.0 + float64(w)*u/2.0, float64(h)/2.0 - float64(h)*v/2.0
+}
+
+// screenOffset returns the world space displacement at p which moves its projection by (dx,dy) pixels.
+func (cam *camera) screenOffset(p Vec3, dx, dy float64, w, h int) Vec3 {
+	scale := 1.0 / cam.zoom
+	if cam.tanHalfFOV > 0.0 {
+		scale *= -p.Subtract(cam.pos).Dot(cam.sz) * cam.tanHalfFOV
+	}
+	return cam.sx.Scale(2.0 * scale * dx / float64(w)).Add(cam.sy.Scale(-2.0 * scale * dy / float64(h)))
 }
 
 // Camera orbit settings.
@@ -41,11 +98,210 @@ func NewCameraOrbit(rollDegree float64, frames int) *CameraOrbit {
 		pos := rz.Scale(ct).Add(rx.Scale(st))
 		sx := rx.Scale(ct).Add(rz.Scale(-st))
 		co.cameras[f] = &camera{
-			pos: pos,
-			sx:  sx,
-			sy:  pos.Cross(sx),
-			sz:  pos,
+			pos:  pos,
+			sx:   sx,
+			sy:   pos.Cross(sx),
+			sz:   pos,
+			zoom: 1.0,
 		}
 	}
 	return co
 }
+
+// SetView zooms and pans all cameras, e.g., zoom 2 shows [-0.5,0.5] around the pan offset instead of [-1,1] for
+// orthographic cameras. pan is in unzoomed screen units, which are world units for orthographic cameras. zoom must be
+// positive.
+func (co *CameraOrbit) SetView(zoom float64, pan [2]float64) error {
+	if !(zoom > 0.0) {
+		return &OptionsError{Field: "zoom", Reason: fmt.Sprintf("must be positive, got %v", zoom)}
+	}
+	for _, cam := range co.cameras {
+		cam.zoom, cam.pan = zoom, pan
+	}
+	return nil
+}
+
+// CameraPose places a camera looking at a point.
+type CameraPose struct {
+	Position Vec3
+	LookAt   Vec3
+	// Up direction on the screen, defaults to the y axis.
+	Up Vec3
+	// Field of view across the screen in degrees for perspective projection, orthographic if zero. As with the
+	// orthographic [-1,1] box, the same field of view applies to both screen axes.
+	FOV float64
+	// Magnification, defaults to 1.
+	Zoom float64
+	// Screen center offset in unzoomed screen units, i.e., world units for orthographic projection.
+	Pan [2]float64
+}
+
+// validate rejects the pose whose view direction is undefined, field naming it in the error.
+func (pose CameraPose) validate(field string) error {
+	if pose.Position == pose.LookAt {
+		return &OptionsError{Field: field, Reason: fmt.Sprintf("Position must differ from LookAt, both are %v", pose.Position)}
+	}
+	if pose.Zoom < 0.0 || math.IsNaN(pose.Zoom) {
+		return &OptionsError{Field: field, Reason: fmt.Sprintf("Zoom must be positive, got %v", pose.Zoom)}
+	}
+	return nil
+}
+
+func (pose CameraPose) camera() *camera {
+	sz := pose.Position.Subtract(pose.LookAt).Normalize()
+	up := pose.Up
+	if up == (Vec3{}) {
+		up = Vec3{0, 1, 0}
+	}
+	sx := up.Cross(sz)
+	if sx.Norm() < 1e-9 {
+		// Looking along the up direction, pick any screen frame.
+		sx, _, _ = planeBasis(sz)
+	}
+	sx = sx.Normalize()
+	zoom := pose.Zoom
+	if zoom == 0.0 {
+		zoom = 1.0
+	}
+	return &camera{
+		pos:        pose.Position,
+		sx:         sx,
+		sy:         sz.Cross(sx),
+		sz:         sz,
+		tanHalfFOV: math.Tan(pose.FOV * math.Pi / 360.0),
+		zoom:       zoom,
+		pan:        pose.Pan,
+	}
+}
+
+// NewCameraLookAt sets up a single frame camera at the given pose.
+func NewCameraLookAt(pose CameraPose) (*CameraOrbit, error) {
+	if err := pose.validate("pose"); err != nil {
+		return nil, err
+	}
+	return &CameraOrbit{cameras: []*camera{pose.camera()}}, nil
+}
+
+// Interpolation between camera keyframes.
+type Interpolation int
+
+const (
+	// Piecewise linear interpolation.
+	Linear Interpolation = iota
+	// Cubic Hermite spline through the keyframes with Catmull-Rom tangents, smooth at the keyframes.
+	Spline
+)
+
+// CameraKeyframe is the camera pose at the given time.
+type CameraKeyframe struct {
+	Time float64
+	Pose CameraPose
+}
+
+// NewCameraPath sets up 'frames' cameras at equally spaced times from the first to the last keyframe, interpolating
+// all pose parameters between keyframes. Keyframe times must be strictly increasing.
+func NewCameraPath(keys []CameraKeyframe, frames int, interp Interpolation) (*CameraOrbit, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("camera path needs at least one keyframe")
+	}
+	if frames <= 0 {
+		return nil, fmt.Errorf("camera path needs at least one frame, got %v", frames)
+	}
+	for i := 1; i < len(keys); i++ {
+		if !(keys[i].Time > keys[i-1].Time) {
+			return nil, fmt.Errorf("camera keyframe times must be strictly increasing, got %v after %v", keys[i].Time, keys[i-1].Time)
+		}
+	}
+	// Interpolate the flattened pose parameters.
+	params := make([][]float64, len(keys))
+	for i, k := range keys {
+		if err := k.Pose.validate(fmt.Sprintf("keys[%v].Pose", i)); err != nil {
+			return nil, err
+		}
+		params[i] = k.Pose.params()
+	}
+	co := &CameraOrbit{cameras: make([]*camera, frames)}
+	t0, t1 := keys[0].Time, keys[len(keys)-1].Time
+	seg := 0
+	for f := range co.cameras {
+		t := t0
+		if frames > 1 {
+			t = t0 + (t1-t0)*float64(f)/float64(frames-1)
+		}
+		for seg+2 < len(keys) && t > keys[seg+1].Time {
+			seg++
+		}
+		var p []float64
+		if len(keys) == 1 {
+			p = params[0]
+		} else {
+			p = interpolate(keys, params, seg, t, interp)
+		}
+		// Valid keyframes may still interpolate to a camera at the point it looks at.
+		pose := poseFromParams(p)
+		if err := pose.validate(fmt.Sprintf("keys[%v:%v]", seg, seg+2)); err != nil {
+			return nil, err
+		}
+		co.cameras[f] = pose.camera()
+	}
+	return co, nil
+}
+
+func (pose CameraPose) params() []float64 {
+	// Apply defaults first so that they get interpolated too.
+	if pose.Up == (Vec3{}) {
+		pose.Up = Vec3{0, 1, 0}
+	}
+	if pose.Zoom == 0.0 {
+		pose.Zoom = 1.0
+	}
+	return []float64{
+		pose.Position[0], pose.Position[1], pose.Position[2],
+		pose.LookAt[0], pose.LookAt[1], pose.LookAt[2],
+		pose.Up[0], pose.Up[1], pose.Up[2],
+		pose.FOV, pose.Zoom, pose.Pan[0], pose.Pan[1],
+	}
+}
+
+func poseFromParams(p []float64) CameraPose {
+	return CameraPose{
+		Position: Vec3{p[0], p[1], p[2]},
+		LookAt:   Vec3{p[3], p[4], p[5]},
+		Up:       Vec3{p[6], p[7], p[8]},
+		FOV:      p[9],
+		Zoom:     p[10],
+		Pan:      [2]float64{p[11], p[12]},
+	}
+}
+
+// interpolate evaluates the parameters at time t within the segment from keyframe i to i+1.
+func interpolate(keys []CameraKeyframe, params [][]float64, i int, t float64, interp Interpolation) []float64 {
+	ta, tb := keys[i].Time, keys[i+1].Time
+	dt := tb - ta
+	s := (t - ta) / dt
+	a, b := params[i], params[i+1]
+	ret := make([]float64, len(a))
+	if interp == Linear {
+		for k := range ret {
+			ret[k] = a[k] + (b[k]-a[k])*s
+		}
+		return ret
+	}
+	// Catmull-Rom tangents with respect to time, one-sided at the ends.
+	tangent := func(j, k int) float64 {
+		lo, hi := j-1, j+1
+		if lo < 0 {
+			lo = j
+		}
+		if hi >= len(keys) {
+			hi = j
+		}
+		return (params[hi][k] - params[lo][k]) / (keys[hi].Time - keys[lo].Time)
+	}
+	s2, s3 := s*s, s*s*s
+	h00, h10, h01, h11 := 2*s3-3*s2+1, s3-2*s2+s, -2*s3+3*s2, s3-s2
+	for k := range ret {
+		ret[k] = h00*a[k] + h10*dt*tangent(i, k) + h01*b[k] + h11*dt*tangent(i+1, k)
+	}
+	return ret
+}
@@ -0,0 +1,387 @@
+package fieldline
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"sort"
+
+	"github.com/fogleman/gg"
+)
+
+// Format is the output format of the rendered frames.
+type Format int
+
+const (
+	// Rasterized PNG images, the default.
+	PNG Format = iota
+	// Scalable vector graphics.
+	SVG
+	// Single page PDF documents, e.g., for embedding into LaTeX.
+	PDF
+)
+
+func (f Format) ext() string {
+	switch f {
+	case SVG:
+		return "svg"
+	case PDF:
+		return "pdf"
+	}
+	return "png"
+}
+
+// canvas is the drawing surface of a single frame, on a black background.
+type canvas interface {
+	// line strokes the segment from (x0,y0) to (x1,y1) in pixel coordinates.
+	line(x0, y0, x1, y1 float64, color [3]float64, alpha float64)
+	// shape fills the paths with the even-odd rule and strokes them in pixel coordinates.
+	shape(paths [][][2]float64, closed bool, style Style)
+	// image draws a full frame image over what has been drawn so far.
+	image(img *image.RGBA)
+	save(filename string) error
+}
+
+func newCanvas(format Format, w, h int, lineWidth float64) canvas {
+	switch format {
+	case SVG:
+		return newSVGCanvas(w, h, lineWidth)
+	case PDF:
+		return newPDFCanvas(w, h, lineWidth)
+	}
+	return newRasterCanvas(w, h, lineWidth)
+}
+
+type rasterCanvas struct {
+	dc        *gg.Context
+	lineWidth float64
+}
+
+func newRasterCanvas(w, h int, lineWidth float64) *rasterCanvas {
+	dc := gg.NewContext(w, h)
+	dc.SetRGB(0, 0, 0)
+	dc.Clear()
+	dc.SetLineWidth(lineWidth)
+	return &rasterCanvas{dc: dc, lineWidth: lineWidth}
+}
+
+func (rc *rasterCanvas) line(x0, y0, x1, y1 float64, color [3]float64, alpha float64) {
+	rc.dc.SetRGBA(color[0], color[1], color[2], alpha)
+	rc.dc.DrawLine(x0, y0, x1, y1)
+	rc.dc.Stroke()
+}
+
+func (rc *rasterCanvas) shape(paths [][][2]float64, closed bool, style Style) {
+	for _, path := range paths {
+		rc.dc.NewSubPath()
+		for _, p := range path {
+			rc.dc.LineTo(p[0], p[1])
+		}
+		if closed {
+			rc.dc.ClosePath()
+		}
+	}
+	if closed && style.FillAlpha > 0.0 {
+		rc.dc.SetFillRuleEvenOdd()
+		rc.dc.SetRGBA(style.Fill[0], style.Fill[1], style.Fill[2], style.FillAlpha)
+		rc.dc.FillPreserve()
+	}
+	if style.StrokeAlpha > 0.0 {
+		rc.dc.SetLineWidth(style.LineWidth)
+		rc.dc.SetRGBA(style.Stroke[0], style.Stroke[1], style.Stroke[2], style.StrokeAlpha)
+		rc.dc.StrokePreserve()
+		rc.dc.SetLineWidth(rc.lineWidth)
+	}
+	rc.dc.ClearPath()
+}
+
+func (rc *rasterCanvas) image(img *image.RGBA) {
+	rc.dc.DrawImage(img, 0, 0)
+}
+
+func (rc *rasterCanvas) save(filename string) error {
+	return rc.dc.SavePNG(filename)
+}
+
+// svgCanvas merges consecutive segments with the same style into a single path.
+type svgCanvas struct {
+	buf bytes.Buffer
+	// Pending path and its style.
+	path  bytes.Buffer
+	style string
+	lastX float64
+	lastY float64
+}
+
+func newSVGCanvas(w, h int, lineWidth float64) *svgCanvas {
+	sc := &svgCanvas{}
+	fmt.Fprintf(&sc.buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n", w, h, w, h)
+	fmt.Fprintf(&sc.buf, "<rect width=\"%d\" height=\"%d\" fill=\"#000000\"/>\n", w, h)
+	fmt.Fprintf(&sc.buf, "<g fill=\"none\" stroke-linecap=\"round\" stroke-linejoin=\"round\" stroke-width=\"%g\">\n", lineWidth)
+	return sc
+}
+
+func (sc *svgCanvas) line(x0, y0, x1, y1 float64, color [3]float64, alpha float64) {
+	style := fmt.Sprintf("stroke=\"%v\" stroke-opacity=\"%.3f\"", hexColor(color), float64(to8bit(alpha))/255.0)
+	if sc.path.Len() == 0 || style != sc.style || x0 != sc.lastX || y0 != sc.lastY {
+		sc.flush()
+		sc.style = style
+		fmt.Fprintf(&sc.path, "M%.2f %.2f", x0, y0)
+	}
+	fmt.Fprintf(&sc.path, "L%.2f %.2f", x1, y1)
+	sc.lastX, sc.lastY = x1, y1
+}
+
+func (sc *svgCanvas) shape(paths [][][2]float64, closed bool, style Style) {
+	sc.flush()
+	var d bytes.Buffer
+	for _, path := range paths {
+		for i, p := range path {
+			op := "L"
+			if i == 0 {
+				op = "M"
+			}
+			fmt.Fprintf(&d, "%v%.2f %.2f", op, p[0], p[1])
+		}
+		if closed {
+			d.WriteString("Z")
+		}
+	}
+	fill := "none"
+	if closed && style.FillAlpha > 0.0 {
+		fill = fmt.Sprintf("%v\" fill-opacity=\"%.3f\" fill-rule=\"evenodd", hexColor(style.Fill), style.FillAlpha)
+	}
+	stroke := "none"
+	if style.StrokeAlpha > 0.0 {
+		stroke = fmt.Sprintf("%v\" stroke-opacity=\"%.3f\" stroke-width=\"%g", hexColor(style.Stroke), style.StrokeAlpha, style.LineWidth)
+	}
+	fmt.Fprintf(&sc.buf, "<path d=\"%s\" fill=\"%s\" stroke=\"%s\"/>\n", d.Bytes(), fill, stroke)
+}
+
+func (sc *svgCanvas) image(img *image.RGBA) {
+	sc.flush()
+	var buf bytes.Buffer
+	// Encoding an in-memory image doesn't fail.
+	png.Encode(&buf, img)
+	b := img.Bounds()
+	fmt.Fprintf(&sc.buf, "<image width=\"%d\" height=\"%d\" href=\"data:image/png;base64,%s\"/>\n",
+		b.Dx(), b.Dy(), base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+func (sc *svgCanvas) flush() {
+	if sc.path.Len() > 0 {
+		fmt.Fprintf(&sc.buf, "<path d=\"%s\" %s/>\n", sc.path.Bytes(), sc.style)
+		sc.path.Reset()
+	}
+}
+
+func (sc *svgCanvas) save(filename string) error {
+	sc.flush()
+	sc.buf.WriteString("</g>\n</svg>\n")
+	return os.WriteFile(filename, sc.buf.Bytes(), 0644)
+}
+
+func hexColor(c [3]float64) string {
+	return fmt.Sprintf("#%02x%02x%02x", to8bit(c[0]), to8bit(c[1]), to8bit(c[2]))
+}
+
+func to8bit(v float64) uint8 {
+	if v <= 0.0 {
+		return 0
+	}
+	if v >= 1.0 {
+		return 255
+	}
+	return uint8(v*255.0 + 0.5)
+}
+
+// pdfCanvas writes a single page PDF with the page size in points equal to the pixel size.
+// Stroke alpha is quantized to 8 bits, with one graphics state per level in use.
+type pdfCanvas struct {
+	w, h      int
+	content   bytes.Buffer
+	lineWidth float64
+	// Stroke and fill alpha levels in use.
+	alphas     map[uint8]bool
+	fillAlphas map[uint8]bool
+	images     []*image.RGBA
+	// Current stroke state to avoid repeating operators.
+	color [3]uint8
+	alpha uint8
+}
+
+func newPDFCanvas(w, h int, lineWidth float64) *pdfCanvas {
+	pc := &pdfCanvas{
+		w:          w,
+		h:          h,
+		lineWidth:  lineWidth,
+		alphas:     map[uint8]bool{255: true},
+		fillAlphas: map[uint8]bool{},
+		alpha:      255,
+	}
+	fmt.Fprintf(&pc.content, "0 0 0 rg 0 0 %d %d re f\n", w, h)
+	fmt.Fprintf(&pc.content, "%g w 1 J 1 j /A255 gs\n", lineWidth)
+	return pc
+}
+
+func (pc *pdfCanvas) setStroke(c [3]uint8, a uint8) {
+	if a != pc.alpha {
+		pc.alphas[a] = true
+		fmt.Fprintf(&pc.content, "/A%d gs\n", a)
+	}
+	if c != pc.color {
+		fmt.Fprintf(&pc.content, "%.3f %.3f %.3f RG\n", float64(c[0])/255.0, float64(c[1])/255.0, float64(c[2])/255.0)
+	}
+	pc.color, pc.alpha = c, a
+}
+
+func (pc *pdfCanvas) line(x0, y0, x1, y1 float64, color [3]float64, alpha float64) {
+	c := [3]uint8{to8bit(color[0]), to8bit(color[1]), to8bit(color[2])}
+	pc.setStroke(c, to8bit(alpha))
+	// PDF has y axis pointing upwards.
+	h := float64(pc.h)
+	fmt.Fprintf(&pc.content, "%.2f %.2f m %.2f %.2f l S\n", x0, h-y0, x1, h-y1)
+}
+
+func (pc *pdfCanvas) shape(paths [][][2]float64, closed bool, style Style) {
+	fill := closed && style.FillAlpha > 0.0
+	stroke := style.StrokeAlpha > 0.0
+	if !fill && !stroke {
+		return
+	}
+	// Keep the line state intact by drawing in a saved graphics state.
+	pc.content.WriteString("q\n")
+	if fill {
+		a := to8bit(style.FillAlpha)
+		pc.fillAlphas[a] = true
+		fmt.Fprintf(&pc.content, "/F%d gs %.3f %.3f %.3f rg\n", a, style.Fill[0], style.Fill[1], style.Fill[2])
+	}
+	if stroke {
+		a := to8bit(style.StrokeAlpha)
+		pc.alphas[a] = true
+		fmt.Fprintf(&pc.content, "/A%d gs %.3f %.3f %.3f RG %g w\n", a, style.Stroke[0], style.Stroke[1], style.Stroke[2], style.LineWidth)
+	}
+	h := float64(pc.h)
+	for _, path := range paths {
+		for i, p := range path {
+			op := "l"
+			if i == 0 {
+				op = "m"
+			}
+			fmt.Fprintf(&pc.content, "%.2f %.2f %v\n", p[0], h-p[1], op)
+		}
+		if closed {
+			pc.content.WriteString("h\n")
+		}
+	}
+	switch {
+	case fill && stroke:
+		pc.content.WriteString("B*\n")
+	case fill:
+		pc.content.WriteString("f*\n")
+	default:
+		pc.content.WriteString("S\n")
+	}
+	pc.content.WriteString("Q\n")
+}
+
+func (pc *pdfCanvas) image(img *image.RGBA) {
+	fmt.Fprintf(&pc.content, "q %d 0 0 %d 0 0 cm /Im%d Do Q\n", pc.w, pc.h, len(pc.images))
+	pc.images = append(pc.images, img)
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (pc *pdfCanvas) save(filename string) error {
+	stream, err := deflate(pc.content.Bytes())
+	if err != nil {
+		return err
+	}
+
+	var levels []int
+	for a := range pc.alphas {
+		levels = append(levels, int(a))
+	}
+	sort.Ints(levels)
+	var gs bytes.Buffer
+	for _, a := range levels {
+		fmt.Fprintf(&gs, "/A%d << /Type /ExtGState /CA %.4f >> ", a, float64(a)/255.0)
+	}
+	levels = levels[:0]
+	for a := range pc.fillAlphas {
+		levels = append(levels, int(a))
+	}
+	sort.Ints(levels)
+	for _, a := range levels {
+		fmt.Fprintf(&gs, "/F%d << /Type /ExtGState /ca %.4f >> ", a, float64(a)/255.0)
+	}
+
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	// Images go after the content stream, as an RGB XObject followed by its alpha soft mask each.
+	var xobjects bytes.Buffer
+	for i := range pc.images {
+		fmt.Fprintf(&xobjects, "/Im%d %d 0 R ", i, 5+2*i)
+	}
+	object(fmt.Sprintf(
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Contents 4 0 R /Resources << /ExtGState << %s>> /XObject << %s>> >> >>",
+		pc.w, pc.h, gs.String(), xobjects.String(),
+	))
+	object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", len(stream), stream))
+	for i, img := range pc.images {
+		b := img.Bounds()
+		rgb := make([]byte, 0, 3*b.Dx()*b.Dy())
+		alpha := make([]byte, 0, b.Dx()*b.Dy())
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := img.RGBAAt(x, y)
+				rgb = append(rgb, c.R, c.G, c.B)
+				alpha = append(alpha, c.A)
+			}
+		}
+		rgbStream, err := deflate(rgb)
+		if err != nil {
+			return err
+		}
+		alphaStream, err := deflate(alpha)
+		if err != nil {
+			return err
+		}
+		object(fmt.Sprintf(
+			"<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /SMask %d 0 R /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream",
+			b.Dx(), b.Dy(), 6+2*i, len(rgbStream), rgbStream,
+		))
+		object(fmt.Sprintf(
+			"<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream",
+			b.Dx(), b.Dy(), len(alphaStream), alphaStream,
+		))
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return os.WriteFile(filename, out.Bytes(), 0644)
+}
@@ -0,0 +1,82 @@
+package fieldline
+
+import (
+	"math"
+
+	"github.com/euphoricrhino/jackson-em-notes/go/pkg/heatmap"
+)
+
+// Colormap colors the lines by a scalar looked up in a heatmap spectrum, instead of the trajectory and symmetry
+// colors. The scalar is normalized across all trajectories.
+type Colormap struct {
+	// Heatmap file and gamma, as for heatmap.Load.
+	HeatMapFile string
+	Gamma       float64
+	// Scalar mapped to colors, e.g., the potential, defaults to the magnitude of TangentAt. It is evaluated on the
+	// traced line and shared by its symmetry copies.
+	Scalar func(Vec3) float64
+	// If true, the logarithm of the scalar is normalized instead. Non-positive values map to the low end.
+	Log bool
+}
+
+// spectrum is a loaded colormap with the normalization range of a run.
+type spectrum struct {
+	colors   [][3]float64
+	log      bool
+	min, max float64
+}
+
+func (cm *Colormap) load() (*spectrum, error) {
+	hm, err := heatmap.Load(cm.HeatMapFile, cm.Gamma)
+	if err != nil {
+		return nil, err
+	}
+	sp := &spectrum{colors: make([][3]float64, len(hm)), log: cm.Log}
+	for i, c := range hm {
+		r, g, b, _ := c.RGBA()
+		sp.colors[i] = [3]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff}
+	}
+	return sp, nil
+}
+
+// value maps the scalar to the normalized quantity, NaN if it has none.
+func (sp *spectrum) value(s float64) float64 {
+	if sp.log {
+		if !(s > 0.0) {
+			return math.NaN()
+		}
+		return math.Log(s)
+	}
+	return s
+}
+
+// fit sets the normalization range to include all given scalars.
+func (sp *spectrum) fit(trajs []Trajectory) {
+	sp.min, sp.max = math.NaN(), math.NaN()
+	for _, traj := range trajs {
+		for _, tp := range traj.line {
+			v := sp.value(tp.scalar)
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			if math.IsNaN(sp.min) || v < sp.min {
+				sp.min = v
+			}
+			if math.IsNaN(sp.max) || v > sp.max {
+				sp.max = v
+			}
+		}
+	}
+}
+
+// color looks up the scalar in the spectrum.
+func (sp *spectrum) color(s float64) [3]float64 {
+	v := sp.value(s)
+	t := 0.5
+	if math.IsNaN(v) {
+		t = 0.0
+	} else if sp.max > sp.min {
+		t = math.Max(0.0, math.Min(1.0, (v-sp.min)/(sp.max-sp.min)))
+	}
+	return sp.colors[int(t*float64(len(sp.colors)-1))]
+}
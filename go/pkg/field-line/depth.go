@@ -0,0 +1,45 @@
+package fieldline
+
+import "sort"
+
+// segment is a line segment in pixel coordinates ready to be drawn.
+type segment struct {
+	x0, y0 float64
+	x1, y1 float64
+	color  [3]float64
+	alpha  float64
+	depth  float64
+	// Arrowhead of the given size drawn instead of the line if set, so that arrows are sorted and cued with the lines.
+	head *arrow
+	size float64
+}
+
+func (seg *segment) draw(cv canvas) {
+	if seg.head != nil {
+		seg.head.draw(cv, seg.size, seg.color, seg.alpha)
+		return
+	}
+	cv.line(seg.x0, seg.y0, seg.x1, seg.y1, seg.color, seg.alpha)
+}
+
+// drawByDepth draws the segments and arrowheads, optionally sorted from far to near and dimmed with depth.
+func drawByDepth(cv canvas, segs []segment, sorted bool, cue float64) {
+	if sorted {
+		sort.SliceStable(segs, func(i, j int) bool { return segs[i].depth > segs[j].depth })
+	}
+	near, far := 0.0, 0.0
+	for i, seg := range segs {
+		if i == 0 || seg.depth < near {
+			near = seg.depth
+		}
+		if i == 0 || seg.depth > far {
+			far = seg.depth
+		}
+	}
+	for i := range segs {
+		if cue > 0.0 && far > near {
+			segs[i].alpha *= 1.0 - cue*(segs[i].depth-near)/(far-near)
+		}
+		segs[i].draw(cv)
+	}
+}
@@ -8,10 +8,14 @@ import (
 )
 
 var (
-	output = flag.String("output", "", "output file")
-	width  = flag.Int("width", 800, "output width")
-	height = flag.Int("height", 800, "output height")
-	step   = flag.Float64("step", 0.005, "step")
+	output   = flag.String("output", "", "output file")
+	width    = flag.Int("width", 800, "output width")
+	height   = flag.Int("height", 800, "output height")
+	step     = flag.Float64("step", 0.002, "arc length step")
+	adaptive = flag.Bool("adaptive", false, "use adaptive step integrator")
+	heatmap  = flag.String("heatmap", "", "if set, color lines by log field magnitude through this heatmap file")
+	sources  = flag.Bool("sources", false, "draw the charges")
+	spacing  = flag.Float64("even-spacing", 0, "if positive, place lines evenly separated by this many pixels")
 )
 
 func main() {
@@ -52,13 +56,13 @@ func main() {
 		for _, charge := range negatives {
 			accum(-1.0, charge)
 		}
-		return v.Scale(1.0 / 2000.0)
+		return v
 	}
 
 	sr := 0.02
 	atEnd := func(p, v fieldline.Vec3) bool {
-		// Stop if the field is too weak.
-		if v.Dot(v) < 1e-8 {
+		// Stop if the field is too weak, the central null region must be wider than a step.
+		if v.Dot(v) < 1.0 {
 			return true
 		}
 		// Stop if we are close the negative charges.
@@ -68,16 +72,27 @@ func main() {
 				return true
 			}
 		}
+		// Lines traced backwards end at the positive charges, which are seeded at radius sr.
+		for _, charge := range positives {
+			d := p.Subtract(charge)
+			if d.Dot(d) < sr*sr/4.0 {
+				return true
+			}
+		}
 		return false
 	}
 
 	var trajs []fieldline.Trajectory
 	samples := 30
 	for _, charge := range positives {
-		for i := 0; i < samples; i++ {
-			theta := math.Pi * 2.0 * float64(i) / float64(samples)
+		// Seed density follows the flux through the small circle, which is distorted by the neighboring charges.
+		seeds, err := fieldline.SeedByFlux(fieldline.SeedCircle{Center: charge, Radius: sr}, tangentAt, samples)
+		if err != nil {
+			panic(err)
+		}
+		for _, seed := range seeds {
 			trajs = append(trajs, fieldline.Trajectory{
-				Start: charge.Add(fieldline.Vec3{sr * math.Cos(theta), sr * math.Sin(theta), 0.0}),
+				Start: seed,
 				AtEnd: atEnd, Color: fieldline.RandColor(),
 			})
 		}
@@ -91,7 +106,46 @@ func main() {
 		TangentAt:   tangentAt,
 		LineWidth:   1.5,
 		FadingGamma: .5,
+		ArcLength:   true,
+	}
+	if *heatmap != "" {
+		opts.Colormap = &fieldline.Colormap{HeatMapFile: *heatmap, Gamma: 1, Log: true}
+		// Colors carry the magnitude already.
+		opts.FadingGamma = 0
+	}
+	if *sources {
+		for _, charge := range positives {
+			opts.Shapes = append(opts.Shapes, chargeShape(charge, [3]float64{1, 0.2, 0.2}))
+		}
+		for _, charge := range negatives {
+			opts.Shapes = append(opts.Shapes, chargeShape(charge, [3]float64{0.2, 0.4, 1}))
+		}
+	}
+	if *adaptive {
+		opts.Integrator = &fieldline.DormandPrince{AbsTol: 1e-5, RelTol: 1e-5, MaxStep: 50 * *step}
+	}
+	if *spacing > 0 {
+		var err error
+		trajs, err = fieldline.EvenlySpaced(opts, trajs, fieldline.EvenSpacing{Separation: *spacing})
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	fieldline.Run(opts, trajs)
+	if err := fieldline.Run(opts, trajs); err != nil {
+		panic(err)
+	}
+}
+
+func chargeShape(at fieldline.Vec3, color [3]float64) fieldline.Shape {
+	return fieldline.Shape{
+		Geometry: fieldline.Point{At: at, Radius: 5},
+		Style: fieldline.Style{
+			Fill:        color,
+			FillAlpha:   1,
+			Stroke:      [3]float64{1, 1, 1},
+			StrokeAlpha: 1,
+			Front:       true,
+		},
+	}
 }
@@ -89,5 +89,7 @@ func main() {
 		})
 	}
 
-	fieldline.Run(opts, trajs)
+	if err := fieldline.Run(opts, trajs); err != nil {
+		panic(err)
+	}
 }
@@ -8,10 +8,11 @@ import (
 )
 
 var (
-	output = flag.String("output", "", "output file")
-	width  = flag.Int("width", 800, "output width")
-	height = flag.Int("height", 800, "output height")
-	step   = flag.Float64("step", 0.01, "step")
+	output   = flag.String("output", "", "output file")
+	width    = flag.Int("width", 800, "output width")
+	height   = flag.Int("height", 800, "output height")
+	step     = flag.Float64("step", 0.01, "step")
+	adaptive = flag.Bool("adaptive", false, "use adaptive step integrator")
 )
 
 func legend(l int, x float64) ([]float64, []float64) {
@@ -102,6 +103,9 @@ func main() {
 		LineWidth:   1.5,
 		FadingGamma: .3,
 	}
+	if *adaptive {
+		opts.Integrator = &fieldline.DormandPrince{AbsTol: 1e-5, RelTol: 1e-5, MaxStep: 50 * *step}
+	}
 
 	ctr := fieldline.Vec3{d, 0, 0}
 	sr := 0.02
@@ -135,5 +139,7 @@ func main() {
 		trajs = append(trajs, traj)
 	}
 
-	fieldline.Run(opts, trajs)
+	if err := fieldline.Run(opts, trajs); err != nil {
+		panic(err)
+	}
 }
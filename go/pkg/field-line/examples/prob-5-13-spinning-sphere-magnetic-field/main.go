@@ -5,6 +5,7 @@ import (
 	"math"
 
 	fieldline "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-line"
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
 )
 
 var (
@@ -12,6 +13,9 @@ var (
 	width  = flag.Int("width", 800, "output width")
 	height = flag.Int("height", 800, "output height")
 	step   = flag.Float64("step", 0.005, "step")
+	frames = flag.Int("frames", 180, "number of frames around the orbit")
+	depth  = flag.Bool("depth", false, "draw lines from back to front with depth cueing, hiding those behind the sphere")
+	anim   = flag.String("animation", "", "if set, encode the frames into this .gif or .png (APNG) file instead of one png per frame")
 )
 
 func main() {
@@ -104,8 +108,36 @@ func main() {
 		TangentAt:   tangentAt,
 		LineWidth:   1.0,
 		FadingGamma: .5,
-		CameraOrbit: fieldline.NewCameraOrbit(30, 180),
+		CameraOrbit: fieldline.NewCameraOrbit(30, *frames),
+		// The dipole field falls off as 1/r^3, guard against lines creeping along far from the sphere.
+		MaxSteps:            200000,
+		StagnationTolerance: 1e-6,
+	}
+	if *depth {
+		opts.DepthSort = true
+		opts.DepthCue = 0.6
+		opts.Occluders = []fieldline.Occluder{{
+			Solid: fieldline.Sphere{Radius: a},
+			Color: [3]float64{0.5, 0.5, 0.5},
+		}}
+	}
+
+	if *anim != "" {
+		var err error
+		if opts.Sink, err = framesink.NewAnimationFile(*anim, 10, nil); err != nil {
+			panic(err)
+		}
 	}
 
-	fieldline.Run(opts, trajs)
+	if err := fieldline.Run(opts, trajs); err != nil {
+		if opts.Sink != nil {
+			opts.Sink.Abort()
+		}
+		panic(err)
+	}
+	if opts.Sink != nil {
+		if err := opts.Sink.Close(); err != nil {
+			panic(err)
+		}
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"math"
 
 	fieldline "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-line"
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
 )
 
 var (
@@ -12,6 +13,10 @@ var (
 	width  = flag.Int("width", 800, "output width")
 	height = flag.Int("height", 800, "output height")
 	step   = flag.Float64("step", 0.005, "step")
+	arrows = flag.Float64("arrows", 0, "if positive, arc length between arrowheads showing the field direction")
+	shell  = flag.Bool("shell", false, "draw the shielding shell")
+	fly    = flag.Int("fly", 0, "if positive, number of frames flying through the shield with a perspective camera")
+	anim   = flag.String("animation", "", "if set, encode the frames into this .gif or .png (APNG) file instead of one png per frame")
 )
 
 func main() {
@@ -119,7 +124,50 @@ func main() {
 		TangentAt:   tangentAt,
 		LineWidth:   1.0,
 		FadingGamma: .25,
+		// Arrows follow the traced tangent, including the sign of scale above.
+		ArrowSpacing: *arrows,
+	}
+	if *shell {
+		// The shell is the region between the two cylinders, drawn as a gray disk with the inside blacked out.
+		opts.Shapes = []fieldline.Shape{
+			{
+				Geometry: fieldline.Circle{Radius: b},
+				Style:    fieldline.Style{Fill: [3]float64{0.4, 0.4, 0.4}, FillAlpha: 0.5},
+			},
+			{
+				Geometry: fieldline.Circle{Radius: a},
+				Style:    fieldline.Style{Fill: [3]float64{0, 0, 0}, FillAlpha: 1},
+			},
+		}
+	}
+	if *fly > 0 {
+		path, err := fieldline.NewCameraPath([]fieldline.CameraKeyframe{
+			{Time: 0, Pose: fieldline.CameraPose{Position: fieldline.Vec3{0, 0, 3}, FOV: 40}},
+			{Time: 1, Pose: fieldline.CameraPose{Position: fieldline.Vec3{1.5, 0.5, 1.5}, FOV: 50}},
+			{Time: 2, Pose: fieldline.CameraPose{Position: fieldline.Vec3{0.2, 0.1, 0.6}, FOV: 70}},
+		}, *fly, fieldline.Spline)
+		if err != nil {
+			panic(err)
+		}
+		opts.CameraOrbit = path
 	}
 
-	fieldline.Run(opts, trajs)
+	if *anim != "" {
+		var err error
+		if opts.Sink, err = framesink.NewAnimationFile(*anim, 10, nil); err != nil {
+			panic(err)
+		}
+	}
+
+	if err := fieldline.Run(opts, trajs); err != nil {
+		if opts.Sink != nil {
+			opts.Sink.Abort()
+		}
+		panic(err)
+	}
+	if opts.Sink != nil {
+		if err := opts.Sink.Close(); err != nil {
+			panic(err)
+		}
+	}
 }
@@ -2,7 +2,10 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"math"
+	"os"
+	"strings"
 
 	fieldline "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-line"
 )
@@ -12,6 +15,9 @@ var (
 	width  = flag.Int("width", 800, "output width")
 	height = flag.Int("height", 800, "output height")
 	step   = flag.Float64("step", 0.01, "step")
+	format = flag.String("format", "png", "output format, png|svg|pdf")
+	plane  = flag.Bool("plane", false, "draw the conducting plane")
+	data   = flag.String("data", "", "if set, write the traced lines to this .csv or .json file instead of rendering")
 )
 
 func main() {
@@ -62,6 +68,10 @@ func main() {
 		LineWidth:   1.5,
 		FadingGamma: 1,
 	}
+	var err error
+	if opts.Format, err = parseFormat(*format); err != nil {
+		panic(err)
+	}
 
 	minrho := -.95 * a
 	maxrho := .95 * a
@@ -71,9 +81,10 @@ func main() {
 	for i := 0; i < samples; i++ {
 		rho := minrho + gap*float64(i)
 		trajs = append(trajs, fieldline.Trajectory{
-			Start: fieldline.Vec3{rho, 0.5, 0},
-			AtEnd: atEnd,
-			Color: fieldline.RandColor(),
+			Start:         fieldline.Vec3{rho, 0.5, 0},
+			AtEnd:         atEnd,
+			Color:         fieldline.RandColor(),
+			Bidirectional: true,
 		})
 	}
 
@@ -84,15 +95,70 @@ func main() {
 	for i := 0; i < outsamples; i++ {
 		rho := minout + outgap*float64(i)
 		trajs = append(trajs, fieldline.Trajectory{
-			Start: fieldline.Vec3{rho, 0.5, 0},
-			AtEnd: atEnd,
-			Color: fieldline.RandColor(),
+			Start:         fieldline.Vec3{rho, 0.5, 0},
+			AtEnd:         atEnd,
+			Color:         fieldline.RandColor(),
+			Bidirectional: true,
 		})
 		trajs = append(trajs, fieldline.Trajectory{
-			Start: fieldline.Vec3{-rho, 0.5, 0},
-			AtEnd: atEnd,
-			Color: fieldline.RandColor(),
+			Start:         fieldline.Vec3{-rho, 0.5, 0},
+			AtEnd:         atEnd,
+			Color:         fieldline.RandColor(),
+			Bidirectional: true,
 		})
 	}
-	fieldline.Run(opts, trajs)
+	if *plane {
+		// The plane is seen edge-on at z=0, with the hole between -a and a.
+		for _, sgn := range []float64{-1, 1} {
+			opts.Shapes = append(opts.Shapes, fieldline.Shape{
+				Geometry: fieldline.Polygon{
+					Vertices: []fieldline.Vec3{{sgn * a, 0, 0}, {sgn * 2, 0, 0}},
+					Open:     true,
+				},
+				Style: fieldline.Style{Stroke: [3]float64{0.8, 0.8, 0.8}, StrokeAlpha: 1, LineWidth: 4},
+			})
+		}
+	}
+	if *data != "" {
+		exportLines(opts, trajs)
+		return
+	}
+	if err := fieldline.Run(opts, trajs); err != nil {
+		panic(err)
+	}
+}
+
+func exportLines(opts fieldline.Options, trajs []fieldline.Trajectory) {
+	// The box shown by the default camera.
+	opts.Bounds = &fieldline.Bounds{Min: fieldline.Vec3{-1, -1, -1}, Max: fieldline.Vec3{1, 1, 1}}
+	lines, err := fieldline.Trace(opts, trajs)
+	if err != nil {
+		panic(err)
+	}
+	f, err := os.Create(*data)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if strings.HasSuffix(*data, ".json") {
+		err = fieldline.WriteJSON(f, lines)
+	} else {
+		err = fieldline.WriteCSV(f, lines)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// parseFormat returns the output format named by the --format flag.
+func parseFormat(name string) (fieldline.Format, error) {
+	switch name {
+	case "png":
+		return fieldline.PNG, nil
+	case "svg":
+		return fieldline.SVG, nil
+	case "pdf":
+		return fieldline.PDF, nil
+	}
+	return 0, fmt.Errorf("unknown format %q, expected png|svg|pdf", name)
 }
@@ -102,5 +102,7 @@ func main() {
 
 	trajs := generateTraj(positives[0], fieldline.Vec3{1, 1, 1}, fieldline.Vec3{0, -1, -1})
 	trajs = append(trajs, generateTraj(positives[1], fieldline.Vec3{-1, 1, -1}, fieldline.Vec3{1, -1, 0})...)
-	fieldline.Run(opts, trajs)
+	if err := fieldline.Run(opts, trajs); err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,45 @@
+package fieldline
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// WriteCSV writes the polylines as CSV with a header row and one row per point.
+func WriteCSV(w io.Writer, lines []Polyline) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{
+		"trajectory", "symmetry", "index", "x", "y", "z", "tangent_length", "forward_stop", "backward_stop",
+	}); err != nil {
+		return err
+	}
+	f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for _, pl := range lines {
+		for k, p := range pl.Points {
+			if err := cw.Write([]string{
+				strconv.Itoa(pl.Trajectory),
+				strconv.Itoa(pl.Symmetry),
+				strconv.Itoa(k),
+				f(p[0]),
+				f(p[1]),
+				f(p[2]),
+				f(pl.TangentLengths[k]),
+				pl.ForwardStop.String(),
+				pl.BackwardStop.String(),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the polylines as a JSON array of objects, one per polyline.
+func WriteJSON(w io.Writer, lines []Polyline) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(lines)
+}
@@ -1,12 +1,13 @@
 package fieldline
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"image"
 	"math"
 	"sync"
 
-	"github.com/fogleman/gg"
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
 )
 
 type pixel [2]int
@@ -17,7 +18,18 @@ func (p pixel) inBound(w, h int) bool {
 
 type trajPoint struct {
 	tangentLength float64
+	scalar        float64
 	pixel
+	// Unrounded pixel coordinates, used by the vector formats.
+	x, y float64
+	// Distance in front of the camera plane.
+	depth float32
+	// Whether an occluder hides the point from the camera.
+	hidden bool
+}
+
+func (tp trajPoint) visible(w, h int) bool {
+	return !tp.hidden && tp.inBound(w, h)
 }
 
 type Symmetry struct {
@@ -33,19 +45,38 @@ func newSymmetry(transform func(Vec3) Vec3, color [3]float64) *Symmetry {
 }
 
 type Trajectory struct {
-	Start      Vec3
-	AtEnd      func(p, v Vec3) bool
-	Color      [3]float64
-	symmetries []*Symmetry
+	Start Vec3
+	AtEnd func(p, v Vec3) bool
+	Color [3]float64
+	// If true, the trajectory is traced both forwards and backwards from Start.
+	Bidirectional bool
+	symmetries    []*Symmetry
 
+	// Whether the identity symmetry has been prepended to symmetries.
+	withIdentity bool
+	// Options and settings the line has been traced with, e.g., by EvenlySpaced, nil if not traced yet.
+	traced *lineKey
+	// Why tracing ended along and against the field direction.
+	forwardStop  StopReason
+	backwardStop StopReason
+	// Traced points in world space, running along the field direction.
+	line []tracePoint
 	// One slice per frame x symmetry.
 	points [][]trajPoint
+	// Arrowheads, one slice per frame x symmetry.
+	arrows [][]arrow
 
 	// Max and min of tangent length along the trajectory.
 	maxTanLen float64
 	minTanLen float64
 }
 
+// Termination returns why tracing ended along and against the field direction. The latter is StopNone unless the
+// trajectory is bidirectional.
+func (traj *Trajectory) Termination() (forward, backward StopReason) {
+	return traj.forwardStop, traj.backwardStop
+}
+
 func (traj *Trajectory) AddSymmetry(transform func(Vec3) Vec3, color [3]float64) {
 	traj.symmetries = append(traj.symmetries, newSymmetry(transform, color))
 }
@@ -54,93 +85,194 @@ type Options struct {
 	OutputFile string
 	Width      int
 	Height     int
-	Step       float64
+	// Initial step size; adaptive integrators adjust it along the trajectory.
+	Step float64
 	// The tangent vector at position represented by the argument.
 	TangentAt   func(Vec3) Vec3
 	LineWidth   float64
 	FadingGamma float64
 	*CameraOrbit
+	// Integrator used for tracing, defaults to fixed-step RK4.
+	Integrator Integrator
+	// Output format of the rendered frames, defaults to PNG.
+	Format Format
+	// If true, trace along the normalized tangent so that each step advances by Step in arc length regardless of
+	// the field magnitude. The true magnitude is still used for fading.
+	ArcLength bool
+
+	// Runaway guards, each disabled if zero. Limits apply to each direction of a bidirectional trajectory.
+	// Maximum number of integration steps.
+	MaxSteps int
+	// Maximum arc length in world space.
+	MaxArcLength float64
+	// A trajectory that returns to within this distance of its start, after having moved away farther than twice
+	// the distance, is considered a closed loop and is closed exactly.
+	LoopTolerance float64
+	// A trajectory that moves less than this distance over 64 steps is considered stagnant, e.g., when creeping into
+	// a null point of the field.
+	StagnationTolerance float64
+	// World space box outside which tracing stops, disabled if nil. Trace, which has no screen for lines to leave,
+	// needs either this or one of MaxSteps and MaxArcLength to stop lines running off to infinity.
+	Bounds *Bounds
+
+	// If true, segments are drawn from far to near so that front lines paint over back lines.
+	DepthSort bool
+	// Dims segments with distance from the camera, from full brightness at the nearest segment of the frame to
+	// 1-DepthCue at the farthest. Disabled if zero.
+	DepthCue float64
+	// Opaque solids drawn underneath the lines, hiding the parts of them behind or inside the solids.
+	Occluders []Occluder
+	// Distance in world space arc length between arrowheads showing the field direction along the lines, disabled if
+	// zero.
+	ArrowSpacing float64
+	// Length of the arrowheads in pixels, defaults to 6 times LineWidth.
+	ArrowSize float64
+	// If set, lines are colored by a scalar through the heatmap instead of the trajectory and symmetry colors.
+	Colormap *Colormap
+	// Scene geometry such as charges and conductors, drawn in order over the occluders and underneath the lines unless
+	// their style says otherwise.
+	Shapes []Shape
+	// If set, the frames of the camera orbit are written in order to the sink instead of being saved one file per
+	// frame. Requires the PNG format.
+	Sink framesink.FrameSink
 }
 
-// Runs the field line renderer given the options and trajectory settings. Upon completion
-// trajs internal data structure would have been modified.
-func Run(opts Options, trajs []Trajectory) {
+// OptionsError reports an invalid setting in Options or in one of the trajectories.
+type OptionsError struct {
+	// Name of the offending field, e.g., "Width" or "trajs[3].AtEnd".
+	Field  string
+	Reason string
+}
+
+func (e *OptionsError) Error() string {
+	return fmt.Sprintf("invalid %v: %v", e.Field, e.Reason)
+}
+
+func (opts *Options) validate(trajs []Trajectory) error {
+	if opts.Width <= 0 {
+		return &OptionsError{Field: "Width", Reason: fmt.Sprintf("must be positive, got %v", opts.Width)}
+	}
+	if opts.Height <= 0 {
+		return &OptionsError{Field: "Height", Reason: fmt.Sprintf("must be positive, got %v", opts.Height)}
+	}
+	if opts.Format != PNG && opts.Format != SVG && opts.Format != PDF {
+		return &OptionsError{Field: "Format", Reason: fmt.Sprintf("must be PNG, SVG or PDF, got %v", int(opts.Format))}
+	}
+	return opts.validateTracing(trajs)
+}
+
+// validateTracing validates the options used for tracing, which unlike rendering needs no screen.
+func (opts *Options) validateTracing(trajs []Trajectory) error {
+	if opts.Bounds != nil && !opts.Bounds.valid() {
+		return &OptionsError{Field: "Bounds", Reason: fmt.Sprintf("must have Min below Max, got %v", *opts.Bounds)}
+	}
+	if !(opts.Step > 0.0) {
+		return &OptionsError{Field: "Step", Reason: fmt.Sprintf("must be positive, got %v", opts.Step)}
+	}
+	if opts.MaxSteps < 0 {
+		return &OptionsError{Field: "MaxSteps", Reason: fmt.Sprintf("must not be negative, got %v", opts.MaxSteps)}
+	}
+	for _, guard := range []struct {
+		field string
+		value float64
+	}{
+		{"MaxArcLength", opts.MaxArcLength},
+		{"LoopTolerance", opts.LoopTolerance},
+		{"StagnationTolerance", opts.StagnationTolerance},
+	} {
+		if guard.value < 0.0 {
+			return &OptionsError{Field: guard.field, Reason: fmt.Sprintf("must not be negative, got %v", guard.value)}
+		}
+	}
+	if opts.ArrowSpacing < 0.0 {
+		return &OptionsError{Field: "ArrowSpacing", Reason: fmt.Sprintf("must not be negative, got %v", opts.ArrowSpacing)}
+	}
+	if opts.DepthCue < 0.0 || opts.DepthCue > 1.0 {
+		return &OptionsError{Field: "DepthCue", Reason: fmt.Sprintf("must be within [0,1], got %v", opts.DepthCue)}
+	}
+	for i, occ := range opts.Occluders {
+		if occ.Solid == nil {
+			return &OptionsError{Field: fmt.Sprintf("Occluders[%v].Solid", i), Reason: "must not be nil"}
+		}
+	}
+	for i, shape := range opts.Shapes {
+		if shape.Geometry == nil {
+			return &OptionsError{Field: fmt.Sprintf("Shapes[%v].Geometry", i), Reason: "must not be nil"}
+		}
+	}
+	if opts.Sink != nil && opts.Format != PNG {
+		return &OptionsError{Field: "Sink", Reason: "requires the PNG format"}
+	}
+	if opts.TangentAt == nil {
+		return &OptionsError{Field: "TangentAt", Reason: "must not be nil"}
+	}
+	for i := range trajs {
+		if trajs[i].AtEnd == nil {
+			return &OptionsError{Field: fmt.Sprintf("trajs[%v].AtEnd", i), Reason: "must not be nil"}
+		}
+	}
+	return nil
+}
+
+func (opts *Options) setDefaults() {
 	if opts.CameraOrbit == nil {
 		opts.CameraOrbit = NewCameraOrbit(0.0, 1)
 	}
-	var wgTrace sync.WaitGroup
-	wgTrace.Add(len(trajs))
-	h := opts.Step
-	h2 := opts.Step * 0.5
-	h6 := opts.Step / 6.0
-	h3 := opts.Step / 3.0
+	if opts.Integrator == nil {
+		opts.Integrator = RK4{}
+	}
+	if opts.ArrowSize == 0.0 {
+		opts.ArrowSize = 6.0 * opts.LineWidth
+	}
+}
+
+// Runs the field line renderer given the options and trajectory settings. Upon completion
+// trajs internal data structure would have been modified.
+func Run(opts Options, trajs []Trajectory) error {
+	return RunContext(context.Background(), opts, trajs)
+}
+
+// RunContext is like Run, but stops tracing and rendering once ctx is done, in which case ctx.Err() is returned
+// and frames not yet saved are skipped.
+func RunContext(ctx context.Context, opts Options, trajs []Trajectory) error {
+	if err := opts.validate(trajs); err != nil {
+		return err
+	}
+	opts.setDefaults()
+	var sp *spectrum
+	if opts.Colormap != nil {
+		var err error
+		if sp, err = opts.Colormap.load(); err != nil {
+			return err
+		}
+	}
+	if err := traceAll(ctx, &opts, trajs, true); err != nil {
+		return err
+	}
+	var wgProject sync.WaitGroup
+	wgProject.Add(len(trajs))
 	for i := range trajs {
 		go func(traj *Trajectory) {
-			defer wgTrace.Done()
-			traj.maxTanLen = -1.0
-			traj.minTanLen = -1.0
-			identity := newSymmetry(func(p Vec3) Vec3 { return p }, traj.Color)
-			traj.symmetries = append([]*Symmetry{identity}, traj.symmetries...)
-			traj.points = make([][]trajPoint, len(opts.cameras)*len(traj.symmetries))
-			// See multi variable Runge Kutta-4 at https://www.myphysicslab.com/explain/runge-kutta-en.html
-			x := traj.Start
-			for {
-				a := opts.TangentAt(x)
-				if traj.AtEnd(x, a) {
-					return
-				}
-				tanlen := a.Norm()
-				allOutOfBound := true
-				for c, camera := range opts.CameraOrbit.cameras {
-					for s, sym := range traj.symmetries {
-						idx := c*len(traj.symmetries) + s
-						tx := sym.transform(x)
-						pt := trajPoint{
-							tangentLength: tanlen,
-							pixel:         camera.worldToScreen(tx, opts.Width, opts.Height),
-						}
-						if pt.inBound(opts.Width, opts.Height) {
-							allOutOfBound = false
-						}
-						newPixel := true
-						// Include a new point only if it's beyond a pixel away.
-						if len(traj.points[idx]) > 0 {
-							last := traj.points[idx][len(traj.points[idx])-1]
-							if last.pixel[0] == pt.pixel[0] && last.pixel[1] == pt.pixel[1] {
-								newPixel = false
-							}
-						}
-						if newPixel {
-							traj.points[idx] = append(traj.points[idx], pt)
-						}
+			defer wgProject.Done()
+			if sp != nil {
+				for k := range traj.line {
+					tp := &traj.line[k]
+					tp.scalar = tp.tangentLength
+					if opts.Colormap.Scalar != nil {
+						tp.scalar = opts.Colormap.Scalar(tp.pos)
 					}
 				}
-
-				if allOutOfBound {
-					return
-				}
-
-				if traj.maxTanLen < 0.0 || traj.maxTanLen < tanlen {
-					traj.maxTanLen = tanlen
-				}
-				if traj.minTanLen < 0.0 || traj.minTanLen > tanlen {
-					traj.minTanLen = tanlen
-				}
-				xb := x.Add(a.Scale(h2))
-				b := opts.TangentAt(xb)
-				xc := x.Add(b.Scale(h2))
-				c := opts.TangentAt(xc)
-				xd := x.Add(c.Scale(h))
-				d := opts.TangentAt(xd)
-				x = x.Add(a.Scale(h6))
-				x = x.Add(b.Scale(h3))
-				x = x.Add(c.Scale(h3))
-				x = x.Add(d.Scale(h6))
+			}
+			traj.project(&opts)
+			if opts.ArrowSpacing > 0.0 {
+				traj.projectArrows(&opts)
 			}
 		}(&trajs[i])
 	}
-
-	wgTrace.Wait()
+	wgProject.Wait()
+	if sp != nil {
+		sp.fit(trajs)
+	}
 
 	fmt.Println("completed tracing all trajectories")
 
@@ -159,26 +291,46 @@ func Run(opts Options, trajs []Trajectory) {
 		max, min = 1.0, 0.0
 	}
 
+	// Frames are handed to the sink in camera order as soon as they and all frames before them have rendered. A
+	// channel is closed without a frame if its camera fails or is canceled.
+	ready := make([]chan image.Image, len(opts.cameras))
+	for c := range ready {
+		ready[c] = make(chan image.Image, 1)
+	}
+	errs := make([]error, len(opts.cameras))
 	var wgRender sync.WaitGroup
 	wgRender.Add(len(opts.cameras))
 	for c := range opts.cameras {
 		go func(cc int) {
 			defer wgRender.Done()
+			defer close(ready[cc])
 
-			dc := gg.NewContext(opts.Width, opts.Height)
-			dc.SetRGB(0, 0, 0)
-			dc.Clear()
+			cam := opts.cameras[cc]
+			cv := newCanvas(opts.Format, opts.Width, opts.Height, opts.LineWidth)
+			if len(opts.Occluders) > 0 {
+				cv.image(cam.occluderLayer(opts.Occluders, opts.Width, opts.Height))
+			}
+			for _, shape := range opts.Shapes {
+				if !shape.Style.Front {
+					drawShape(cv, cam, &opts, shape)
+				}
+			}
 
-			dc.SetLineWidth(opts.LineWidth)
+			// Segments are drawn right away unless they need to be sorted or cued by depth first.
+			deferred := opts.DepthSort || opts.DepthCue > 0.0
+			var segs []segment
 
 			for _, traj := range trajs {
+				if ctx.Err() != nil {
+					return
+				}
 				for j := range traj.symmetries {
 					points := traj.points[cc*len(traj.symmetries)+j]
 					start := 0
 					for {
-						// Search for the next in-bound pixel.
+						// Search for the next visible pixel.
 						for start < len(points) {
-							if points[start].inBound(opts.Width, opts.Height) {
+							if points[start].visible(opts.Width, opts.Height) {
 								break
 							}
 							start++
@@ -187,10 +339,10 @@ func Run(opts Options, trajs []Trajectory) {
 							// No more pixels along this trajectory.
 							break
 						}
-						// Search for the next out-of-bound pixel.
+						// Search for the next invisible pixel.
 						end := start + 1
 						for end < len(points) {
-							if !points[end].inBound(opts.Width, opts.Height) {
+							if !points[end].visible(opts.Width, opts.Height) {
 								break
 							}
 							end++
@@ -199,26 +351,103 @@ func Run(opts Options, trajs []Trajectory) {
 						for p := start; p < end-1; p++ {
 							// Determine the alpha of this segment based on the ratio of average tangent length to the max tangent length.
 							avg := (points[p].tangentLength + points[p+1].tangentLength) / 2.0
-							alpha := math.Pow((avg-min)/(max-min), opts.FadingGamma)
-							dc.SetRGBA(traj.symmetries[j].color[0], traj.symmetries[j].color[1], traj.symmetries[j].color[2], alpha)
-							dc.DrawLine(
-								float64(points[p].pixel[0]),
-								float64(points[p].pixel[1]),
-								float64(points[p+1].pixel[0]),
-								float64(points[p+1].pixel[1]),
-							)
-							dc.Stroke()
+							seg := segment{
+								x0:    float64(points[p].pixel[0]),
+								y0:    float64(points[p].pixel[1]),
+								x1:    float64(points[p+1].pixel[0]),
+								y1:    float64(points[p+1].pixel[1]),
+								color: traj.symmetries[j].color,
+								alpha: math.Pow((avg-min)/(max-min), opts.FadingGamma),
+								depth: float64(points[p].depth+points[p+1].depth) / 2.0,
+							}
+							if opts.Format != PNG {
+								// Scalable output keeps the exact projections rather than snapping them to pixels.
+								seg.x0, seg.y0 = points[p].x, points[p].y
+								seg.x1, seg.y1 = points[p+1].x, points[p+1].y
+							}
+							if sp != nil {
+								seg.color = sp.color((points[p].scalar + points[p+1].scalar) / 2.0)
+							}
+							if deferred {
+								segs = append(segs, seg)
+							} else {
+								seg.draw(cv)
+							}
 						}
 						start = end + 1
 					}
 				}
 			}
+			if opts.ArrowSpacing > 0.0 {
+				for _, traj := range trajs {
+					for j, sym := range traj.symmetries {
+						arrows := traj.arrows[cc*len(traj.symmetries)+j]
+						for k := range arrows {
+							a := &arrows[k]
+							seg := segment{
+								color: sym.color,
+								alpha: math.Pow((a.tangentLength-min)/(max-min), opts.FadingGamma),
+								depth: a.depth,
+								head:  a,
+								size:  opts.ArrowSize,
+							}
+							if sp != nil {
+								seg.color = sp.color(a.scalar)
+							}
+							if deferred {
+								segs = append(segs, seg)
+							} else {
+								seg.draw(cv)
+							}
+						}
+					}
+				}
+			}
+			if deferred {
+				drawByDepth(cv, segs, opts.DepthSort, opts.DepthCue)
+			}
+			for _, shape := range opts.Shapes {
+				if shape.Style.Front {
+					drawShape(cv, cam, &opts, shape)
+				}
+			}
 
-			filename := fmt.Sprintf("%v-%03d-of-%03d.png", opts.OutputFile, cc, len(opts.cameras))
-			if err := dc.SavePNG(filename); err != nil {
-				log.Fatalf("failed to save %v: %v", filename, err)
+			if opts.Sink != nil {
+				ready[cc] <- cv.(*rasterCanvas).dc.Image()
+				return
+			}
+			filename := fmt.Sprintf("%v-%03d-of-%03d.%v", opts.OutputFile, cc, len(opts.cameras), opts.Format.ext())
+			if err := cv.save(filename); err != nil {
+				errs[cc] = fmt.Errorf("failed to save %v: %v", filename, err)
 			}
 		}(c)
 	}
+	var sinkErr error
+	if opts.Sink != nil {
+		// Once a frame is missing, which only happens on cancellation, or the sink fails, the remaining frames are
+		// drained without being written so that none is written out of order.
+		stopped := false
+		for cc := range ready {
+			frame, ok := <-ready[cc]
+			if !ok {
+				stopped = true
+			}
+			if stopped || sinkErr != nil {
+				continue
+			}
+			if err := opts.Sink.Write(framesink.Frame{Index: cc, Image: frame}); err != nil {
+				sinkErr = err
+			}
+		}
+	}
 	wgRender.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return sinkErr
 }
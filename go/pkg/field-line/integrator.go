@@ -0,0 +1,123 @@
+package fieldline
+
+import "math"
+
+// Integrator advances a point along the tangent field by one step.
+type Integrator interface {
+	// Advance moves x along the tangent field f with step size h, where fx is the already evaluated f(x).
+	// It returns the new position and the step size to use for the next step.
+	Advance(f func(Vec3) Vec3, x, fx Vec3, h float64) (Vec3, float64)
+}
+
+// RK4 is the classical fixed-step Runge-Kutta-4 integrator, used by default.
+type RK4 struct{}
+
+// Advance implements Integrator.
+func (RK4) Advance(f func(Vec3) Vec3, x, a Vec3, h float64) (Vec3, float64) {
+	// See multi variable Runge Kutta-4 at https://www.myphysicslab.com/explain/runge-kutta-en.html
+	h2 := h * 0.5
+	h6 := h / 6.0
+	h3 := h / 3.0
+	xb := x.Add(a.Scale(h2))
+	b := f(xb)
+	xc := x.Add(b.Scale(h2))
+	c := f(xc)
+	xd := x.Add(c.Scale(h))
+	d := f(xd)
+	x = x.Add(a.Scale(h6))
+	x = x.Add(b.Scale(h3))
+	x = x.Add(c.Scale(h3))
+	x = x.Add(d.Scale(h6))
+	return x, h
+}
+
+// DormandPrince is the embedded Runge-Kutta 5(4) integrator of Dormand and Prince, which adapts the step size
+// so that the estimated local error stays within the tolerances.
+// Zero fields take the defaults AbsTol=RelTol=1e-6, MinStep=1e-8 and unbounded MaxStep.
+type DormandPrince struct {
+	AbsTol  float64
+	RelTol  float64
+	MinStep float64
+	MaxStep float64
+}
+
+// Butcher tableau of Dormand-Prince 5(4), see https://en.wikipedia.org/wiki/Dormand%E2%80%93Prince_method
+var (
+	dpA = [7][6]float64{
+		{},
+		{1.0 / 5.0},
+		{3.0 / 40.0, 9.0 / 40.0},
+		{44.0 / 45.0, -56.0 / 15.0, 32.0 / 9.0},
+		{19372.0 / 6561.0, -25360.0 / 2187.0, 64448.0 / 6561.0, -212.0 / 729.0},
+		{9017.0 / 3168.0, -355.0 / 33.0, 46732.0 / 5247.0, 49.0 / 176.0, -5103.0 / 18656.0},
+		{35.0 / 384.0, 0.0, 500.0 / 1113.0, 125.0 / 192.0, -2187.0 / 6784.0, 11.0 / 84.0},
+	}
+	// Difference between the 5th and the 4th order weights.
+	dpE = [7]float64{
+		71.0 / 57600.0, 0.0, -71.0 / 16695.0, 71.0 / 1920.0, -17253.0 / 339200.0, 22.0 / 525.0, -1.0 / 40.0,
+	}
+)
+
+func (dp *DormandPrince) withDefaults() DormandPrince {
+	ret := *dp
+	if ret.AbsTol <= 0.0 {
+		ret.AbsTol = 1e-6
+	}
+	if ret.RelTol <= 0.0 {
+		ret.RelTol = 1e-6
+	}
+	if ret.MinStep <= 0.0 {
+		ret.MinStep = 1e-8
+	}
+	if ret.MaxStep <= 0.0 {
+		ret.MaxStep = math.Inf(1)
+	}
+	return ret
+}
+
+// Advance implements Integrator.
+func (dp *DormandPrince) Advance(f func(Vec3) Vec3, x, fx Vec3, h float64) (Vec3, float64) {
+	o := dp.withDefaults()
+	h = math.Min(math.Max(h, o.MinStep), o.MaxStep)
+	var k [7]Vec3
+	k[0] = fx
+	for {
+		for s := 1; s < 7; s++ {
+			xs := x
+			for j := 0; j < s; j++ {
+				xs = xs.Add(k[j].Scale(h * dpA[s][j]))
+			}
+			k[s] = f(xs)
+		}
+		// The 7th stage is evaluated at the 5th order solution (first same as last).
+		next := x
+		for j := 0; j < 6; j++ {
+			next = next.Add(k[j].Scale(h * dpA[6][j]))
+		}
+		var e Vec3
+		for j := 0; j < 7; j++ {
+			e = e.Add(k[j].Scale(h * dpE[j]))
+		}
+		// RMS of the error scaled by the mixed absolute/relative tolerance.
+		errSum := 0.0
+		for i := 0; i < 3; i++ {
+			sc := o.AbsTol + o.RelTol*math.Max(math.Abs(x[i]), math.Abs(next[i]))
+			errSum += (e[i] / sc) * (e[i] / sc)
+		}
+		errNorm := math.Sqrt(errSum / 3.0)
+		if math.IsNaN(errNorm) {
+			// Nothing sensible to control, leave it to AtEnd.
+			return next, h
+		}
+
+		// Standard step size controller with safety factor 0.9 and growth limited to [0.2,5].
+		factor := 5.0
+		if errNorm > 0.0 {
+			factor = math.Min(5.0, math.Max(0.2, 0.9*math.Pow(errNorm, -0.2)))
+		}
+		if errNorm <= 1.0 || h <= o.MinStep {
+			return next, math.Min(math.Max(h*factor, o.MinStep), o.MaxStep)
+		}
+		h = math.Max(h*factor, o.MinStep)
+	}
+}
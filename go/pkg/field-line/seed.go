@@ -0,0 +1,156 @@
+package fieldline
+
+import (
+	"fmt"
+	"math"
+)
+
+// SeedSurface is a surface, or a curve for planar plots, on which trajectories are seeded.
+type SeedSurface interface {
+	// at maps (u,v) in the unit square to a point on the surface and the vector area element there, i.e., the unit
+	// normal scaled by the Jacobian of the parameterization.
+	at(u, v float64) (Vec3, Vec3)
+	// grid returns the number of cells along u and v used to tabulate the flux.
+	grid() (int, int)
+}
+
+// SeedSphere is the sphere around Center, with outward normal.
+type SeedSphere struct {
+	Center Vec3
+	Radius float64
+}
+
+func (s SeedSphere) at(u, v float64) (Vec3, Vec3) {
+	theta, phi := math.Pi*u, 2.0*math.Pi*v
+	st, ct := math.Sin(theta), math.Cos(theta)
+	n := Vec3{st * math.Cos(phi), st * math.Sin(phi), ct}
+	return s.Center.Add(n.Scale(s.Radius)), n.Scale(2.0 * math.Pi * math.Pi * s.Radius * s.Radius * st)
+}
+
+func (s SeedSphere) grid() (int, int) { return 64, 128 }
+
+// SeedCircle is the circle around Center in the plane perpendicular to Normal (z axis if zero), with outward normal
+// in that plane. This is the planar counterpart of SeedSphere.
+type SeedCircle struct {
+	Center Vec3
+	Normal Vec3
+	Radius float64
+}
+
+func (c SeedCircle) at(u, _ float64) (Vec3, Vec3) {
+	e1, e2, _ := planeBasis(c.Normal)
+	phi := 2.0 * math.Pi * u
+	n := e1.Scale(math.Cos(phi)).Add(e2.Scale(math.Sin(phi)))
+	return c.Center.Add(n.Scale(c.Radius)), n.Scale(2.0 * math.Pi * c.Radius)
+}
+
+func (c SeedCircle) grid() (int, int) { return 512, 1 }
+
+// SeedDisk is the flat disk around Center perpendicular to Normal (z axis if zero), with normal along Normal.
+type SeedDisk struct {
+	Center Vec3
+	Normal Vec3
+	Radius float64
+}
+
+func (d SeedDisk) at(u, v float64) (Vec3, Vec3) {
+	e1, e2, n := planeBasis(d.Normal)
+	// Radius goes with sqrt(u) so that the Jacobian is constant.
+	rho, phi := d.Radius*math.Sqrt(u), 2.0*math.Pi*v
+	p := d.Center.Add(e1.Scale(rho * math.Cos(phi))).Add(e2.Scale(rho * math.Sin(phi)))
+	return p, n.Scale(math.Pi * d.Radius * d.Radius)
+}
+
+func (d SeedDisk) grid() (int, int) { return 64, 128 }
+
+// SeedSegment is the line segment from A to B in the plane perpendicular to Normal (z axis if zero). Its normal is
+// (B-A)xNormal, i.e., pointing to the right when walking from A to B with Normal pointing at the viewer.
+type SeedSegment struct {
+	A      Vec3
+	B      Vec3
+	Normal Vec3
+}
+
+func (s SeedSegment) at(u, _ float64) (Vec3, Vec3) {
+	_, _, n := planeBasis(s.Normal)
+	d := s.B.Subtract(s.A)
+	return s.A.Add(d.Scale(u)), d.Cross(n)
+}
+
+func (s SeedSegment) grid() (int, int) { return 512, 1 }
+
+// planeBasis returns an orthonormal frame (e1, e2, n) with n along the given normal, or the z axis if it's zero.
+func planeBasis(normal Vec3) (Vec3, Vec3, Vec3) {
+	n := Vec3{0, 0, 1}
+	if normal != (Vec3{}) {
+		n = normal.Normalize()
+	}
+	ref := Vec3{1, 0, 0}
+	if math.Abs(n[0]) > 0.9 {
+		ref = Vec3{0, 1, 0}
+	}
+	e1 := ref.Subtract(n.Scale(ref.Dot(n))).Normalize()
+	return e1, n.Cross(e1), n
+}
+
+// Golden ratio conjugate, for low discrepancy sampling of the second parameter.
+var goldenConj = (math.Sqrt(5.0) - 1.0) / 2.0
+
+// SeedByFlux places n seeds on the surface such that the number of seeds through each patch is proportional to the
+// magnitude of the field flux through it, so that the density of traced lines reflects the field strength.
+// Seeds where the field enters the surface should be traced backwards, e.g., with Trajectory.Bidirectional.
+// Returns an error if n is not positive or there is no flux through the surface.
+func SeedByFlux(surface SeedSurface, field func(Vec3) Vec3, n int) ([]Vec3, error) {
+	if n <= 0 {
+		return nil, &OptionsError{Field: "n", Reason: fmt.Sprintf("must be positive, got %v", n)}
+	}
+	nu, nv := surface.grid()
+	// Tabulate the flux at cell centers, then build the marginal CDF along u and the conditional CDF along v per row.
+	rows := make([][]float64, nu)
+	marginal := make([]float64, nu+1)
+	for i := 0; i < nu; i++ {
+		rows[i] = make([]float64, nv+1)
+		for j := 0; j < nv; j++ {
+			p, da := surface.at((float64(i)+0.5)/float64(nu), (float64(j)+0.5)/float64(nv))
+			flux := math.Abs(field(p).Dot(da))
+			if math.IsNaN(flux) || math.IsInf(flux, 0) {
+				flux = 0.0
+			}
+			rows[i][j+1] = rows[i][j] + flux
+		}
+		marginal[i+1] = marginal[i] + rows[i][nv]
+	}
+	if !(marginal[nu] > 0.0) {
+		return nil, &OptionsError{Field: "field", Reason: "has no flux through the surface"}
+	}
+	seeds := make([]Vec3, n)
+	for k := range seeds {
+		s := (float64(k) + 0.5) / float64(n)
+		t := math.Mod((float64(k)+0.5)*goldenConj, 1.0)
+		i, u := invertCDF(marginal, s)
+		_, v := invertCDF(rows[i], t)
+		seeds[k], _ = surface.at(u, v)
+	}
+	return seeds, nil
+}
+
+// invertCDF finds the cell i such that cdf[i] <= x*total < cdf[i+1] for the cumulative table cdf (starting at 0),
+// and returns it along with the linearly interpolated parameter in [0,1].
+func invertCDF(cdf []float64, x float64) (int, float64) {
+	cells := len(cdf) - 1
+	target := x * cdf[cells]
+	lo, hi := 0, cells-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if cdf[mid] <= target {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	frac := 0.5
+	if w := cdf[lo+1] - cdf[lo]; w > 0.0 {
+		frac = (target - cdf[lo]) / w
+	}
+	return lo, (float64(lo) + frac) / float64(cells)
+}
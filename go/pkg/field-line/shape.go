@@ -0,0 +1,215 @@
+package fieldline
+
+import (
+	"math"
+	"sort"
+)
+
+// Style of a shape. A zero alpha disables the fill or the stroke.
+type Style struct {
+	Fill        [3]float64
+	FillAlpha   float64
+	Stroke      [3]float64
+	StrokeAlpha float64
+	// Defaults to Options.LineWidth.
+	LineWidth float64
+	// If true, the shape is drawn over the lines instead of underneath, e.g., for point charges where lines converge.
+	Front bool
+}
+
+// Geometry is something drawable in a scene.
+type Geometry interface {
+	// outline returns the screen space paths of the geometry transformed by the given symmetry, and whether the
+	// paths are closed. Paths with points behind the camera are dropped.
+	outline(cam *camera, transform func(Vec3) Vec3, w, h int) ([][][2]float64, bool)
+}
+
+// Shape is a geometry in the scene, drawn for every camera and symmetry.
+type Shape struct {
+	Geometry   Geometry
+	Style      Style
+	symmetries []func(Vec3) Vec3
+}
+
+// AddSymmetry draws a copy of the shape transformed by the given function, which should be an isometry for spheres
+// and points.
+func (s *Shape) AddSymmetry(transform func(Vec3) Vec3) {
+	s.symmetries = append(s.symmetries, transform)
+}
+
+func drawShape(cv canvas, cam *camera, opts *Options, shape Shape) {
+	style := shape.Style
+	if style.LineWidth == 0.0 {
+		style.LineWidth = opts.LineWidth
+	}
+	transforms := append([]func(Vec3) Vec3{func(p Vec3) Vec3 { return p }}, shape.symmetries...)
+	for _, transform := range transforms {
+		if paths, closed := shape.Geometry.outline(cam, transform, opts.Width, opts.Height); len(paths) > 0 {
+			cv.shape(paths, closed, style)
+		}
+	}
+}
+
+// Number of vertices used for round outlines.
+const roundVertices = 128
+
+// Point is a marker of fixed pixel radius, e.g., for point charges.
+type Point struct {
+	At Vec3
+	// Radius in pixels, defaults to 4.
+	Radius float64
+}
+
+func (pt Point) outline(cam *camera, transform func(Vec3) Vec3, w, h int) ([][][2]float64, bool) {
+	p := transform(pt.At)
+	if _, _, ok := cam.screen(p); !ok {
+		return nil, true
+	}
+	x, y := cam.project(p, w, h)
+	r := pt.Radius
+	if r == 0.0 {
+		r = 4.0
+	}
+	ring := make([][2]float64, roundVertices/4)
+	for i := range ring {
+		phi := 2.0 * math.Pi * float64(i) / float64(len(ring))
+		ring[i] = [2]float64{x + r*math.Cos(phi), y + r*math.Sin(phi)}
+	}
+	return [][][2]float64{ring}, true
+}
+
+// Circle is the circle around Center in the plane perpendicular to Normal (z axis if zero).
+type Circle struct {
+	Center Vec3
+	Normal Vec3
+	Radius float64
+}
+
+func (c Circle) outline(cam *camera, transform func(Vec3) Vec3, w, h int) ([][][2]float64, bool) {
+	return projectPaths(cam, w, h, circleRing(c.Center, c.Normal, c.Radius, transform)), true
+}
+
+// Polygon is the closed polygon through the vertices, or the polyline through them if Open.
+type Polygon struct {
+	Vertices []Vec3
+	Open     bool
+}
+
+func (pg Polygon) outline(cam *camera, transform func(Vec3) Vec3, w, h int) ([][][2]float64, bool) {
+	ring := make([]Vec3, len(pg.Vertices))
+	for i, v := range pg.Vertices {
+		ring[i] = transform(v)
+	}
+	return projectPaths(cam, w, h, ring), !pg.Open
+}
+
+// PlaneWithHole is the square of half side Size around Center perpendicular to Normal (z axis if zero), with a
+// circular hole of radius HoleRadius in the middle, e.g., for the conducting plane of section 3.13.
+type PlaneWithHole struct {
+	Center     Vec3
+	Normal     Vec3
+	Size       float64
+	HoleRadius float64
+}
+
+func (pl PlaneWithHole) outline(cam *camera, transform func(Vec3) Vec3, w, h int) ([][][2]float64, bool) {
+	e1, e2, _ := planeBasis(pl.Normal)
+	var square []Vec3
+	for _, c := range [][2]float64{{-1, -1}, {1, -1}, {1, 1}, {-1, 1}} {
+		square = append(square, transform(pl.Center.Add(e1.Scale(c[0]*pl.Size)).Add(e2.Scale(c[1]*pl.Size))))
+	}
+	paths := [][]Vec3{square}
+	if pl.HoleRadius > 0.0 {
+		paths = append(paths, circleRing(pl.Center, pl.Normal, pl.HoleRadius, transform))
+	}
+	return projectPaths(cam, w, h, paths...), true
+}
+
+// The outline of a sphere is its silhouette, i.e., the circle where the lines of sight touch it.
+func (s Sphere) outline(cam *camera, transform func(Vec3) Vec3, w, h int) ([][][2]float64, bool) {
+	c := transform(s.Center)
+	identity := func(p Vec3) Vec3 { return p }
+	if cam.tanHalfFOV == 0.0 {
+		return projectPaths(cam, w, h, circleRing(c, cam.sz, s.Radius, identity)), true
+	}
+	toCam := cam.pos.Subtract(c)
+	d := toCam.Norm()
+	if d <= s.Radius {
+		// The camera is inside the sphere.
+		return nil, true
+	}
+	n := toCam.Scale(1.0 / d)
+	r := s.Radius * math.Sqrt(d*d-s.Radius*s.Radius) / d
+	return projectPaths(cam, w, h, circleRing(c.Add(n.Scale(s.Radius*s.Radius/d)), n, r, identity)), true
+}
+
+// The outline of a cylinder is the convex hull of its end caps on the screen.
+func (cy Cylinder) outline(cam *camera, transform func(Vec3) Vec3, w, h int) ([][][2]float64, bool) {
+	axis := cy.B.Subtract(cy.A)
+	ends := append(
+		circleRing(cy.A, axis, cy.Radius, transform),
+		circleRing(cy.B, axis, cy.Radius, transform)...,
+	)
+	paths := projectPaths(cam, w, h, ends)
+	if len(paths) == 0 {
+		return nil, true
+	}
+	return [][][2]float64{convexHull(paths[0])}, true
+}
+
+// circleRing returns the vertices of the circle around center perpendicular to normal, transformed.
+func circleRing(center, normal Vec3, radius float64, transform func(Vec3) Vec3) []Vec3 {
+	e1, e2, _ := planeBasis(normal)
+	ring := make([]Vec3, roundVertices)
+	for i := range ring {
+		phi := 2.0 * math.Pi * float64(i) / float64(roundVertices)
+		ring[i] = transform(center.Add(e1.Scale(radius * math.Cos(phi))).Add(e2.Scale(radius * math.Sin(phi))))
+	}
+	return ring
+}
+
+// projectPaths projects the world space paths to the screen, dropping those with points behind the camera.
+func projectPaths(cam *camera, w, h int, paths ...[]Vec3) [][][2]float64 {
+	var ret [][][2]float64
+	for _, path := range paths {
+		sp := make([][2]float64, 0, len(path))
+		for _, p := range path {
+			if _, _, ok := cam.screen(p); !ok {
+				break
+			}
+			x, y := cam.project(p, w, h)
+			sp = append(sp, [2]float64{x, y})
+		}
+		if len(sp) == len(path) && len(sp) > 0 {
+			ret = append(ret, sp)
+		}
+	}
+	return ret
+}
+
+// convexHull returns the convex hull of the points in counterclockwise order, by Andrew's monotone chain.
+func convexHull(pts [][2]float64) [][2]float64 {
+	pts = append([][2]float64(nil), pts...)
+	sort.Slice(pts, func(i, j int) bool {
+		return pts[i][0] < pts[j][0] || pts[i][0] == pts[j][0] && pts[i][1] < pts[j][1]
+	})
+	cross := func(o, a, b [2]float64) float64 {
+		return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+	}
+	var hull [][2]float64
+	for pass := 0; pass < 2; pass++ {
+		start := len(hull)
+		for _, p := range pts {
+			for len(hull) >= start+2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0.0 {
+				hull = hull[:len(hull)-1]
+			}
+			hull = append(hull, p)
+		}
+		// The last point is the first of the other chain.
+		hull = hull[:len(hull)-1]
+		for i, j := 0, len(pts)-1; i < j; i, j = i+1, j-1 {
+			pts[i], pts[j] = pts[j], pts[i]
+		}
+	}
+	return hull
+}
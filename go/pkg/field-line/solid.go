@@ -0,0 +1,150 @@
+package fieldline
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Solid is a closed surface that can be hit by rays.
+type Solid interface {
+	// intersect returns the smallest t > rayEpsilon such that o+t*d is on the surface, and the outward unit normal
+	// there.
+	intersect(o, d Vec3) (float64, Vec3, bool)
+}
+
+// Rays starting on a surface must not hit it again right away.
+const rayEpsilon = 1e-9
+
+// Sphere is the solid ball around Center.
+type Sphere struct {
+	Center Vec3
+	Radius float64
+}
+
+func (s Sphere) intersect(o, d Vec3) (float64, Vec3, bool) {
+	oc := o.Subtract(s.Center)
+	t, ok := smallestRoot(d.Dot(d), 2.0*oc.Dot(d), oc.Dot(oc)-s.Radius*s.Radius)
+	if !ok {
+		return 0.0, Vec3{}, false
+	}
+	return t, o.Add(d.Scale(t)).Subtract(s.Center).Normalize(), true
+}
+
+// Cylinder is the solid cylinder with axis from A to B, capped at both ends.
+type Cylinder struct {
+	A      Vec3
+	B      Vec3
+	Radius float64
+}
+
+func (c Cylinder) intersect(o, d Vec3) (float64, Vec3, bool) {
+	axis := c.B.Subtract(c.A)
+	length := axis.Norm()
+	axis = axis.Scale(1.0 / length)
+	// Side surface, in the plane perpendicular to the axis.
+	oa := o.Subtract(c.A)
+	op := oa.Subtract(axis.Scale(oa.Dot(axis)))
+	dp := d.Subtract(axis.Scale(d.Dot(axis)))
+	best, normal, hit := 0.0, Vec3{}, false
+	qa, qb, qc := dp.Dot(dp), 2.0*op.Dot(dp), op.Dot(op)-c.Radius*c.Radius
+	if disc := qb*qb - 4.0*qa*qc; qa > 0.0 && disc >= 0.0 {
+		sq := math.Sqrt(disc)
+		for _, t := range []float64{(-qb - sq) / (2.0 * qa), (-qb + sq) / (2.0 * qa)} {
+			if t <= rayEpsilon {
+				continue
+			}
+			h := oa.Add(d.Scale(t)).Dot(axis)
+			if h >= 0.0 && h <= length {
+				best, normal, hit = t, op.Add(dp.Scale(t)).Normalize(), true
+				break
+			}
+		}
+	}
+	// End caps.
+	if dn := d.Dot(axis); dn != 0.0 {
+		for _, cap := range []struct {
+			center Vec3
+			normal Vec3
+		}{{c.A, axis.Scale(-1.0)}, {c.B, axis}} {
+			t := cap.center.Subtract(o).Dot(axis) / dn
+			if t <= rayEpsilon || hit && t >= best {
+				continue
+			}
+			if r := o.Add(d.Scale(t)).Subtract(cap.center); r.Dot(r) <= c.Radius*c.Radius {
+				best, normal, hit = t, cap.normal, true
+			}
+		}
+	}
+	return best, normal, hit
+}
+
+// smallestRoot returns the smallest root of a*t^2+b*t+c greater than rayEpsilon.
+func smallestRoot(a, b, c float64) (float64, bool) {
+	disc := b*b - 4.0*a*c
+	if a == 0.0 || disc < 0.0 {
+		return 0.0, false
+	}
+	sq := math.Sqrt(disc)
+	if t := (-b - sq) / (2.0 * a); t > rayEpsilon {
+		return t, true
+	}
+	if t := (-b + sq) / (2.0 * a); t > rayEpsilon {
+		return t, true
+	}
+	return 0.0, false
+}
+
+// Occluder is an opaque solid drawn underneath the lines, hiding the parts of them behind or inside it.
+type Occluder struct {
+	Solid Solid
+	Color [3]float64
+}
+
+// hidden returns whether p is hidden from the camera by any of the occluders.
+func (cam *camera) hidden(p Vec3, occluders []Occluder) bool {
+	// March from p towards the camera.
+	d, tmax := cam.sz, math.Inf(1)
+	if cam.tanHalfFOV > 0.0 {
+		d, tmax = cam.pos.Subtract(p), 1.0
+	}
+	for _, occ := range occluders {
+		if t, _, ok := occ.Solid.intersect(p, d); ok && t < tmax {
+			return true
+		}
+	}
+	return false
+}
+
+// occluderLayer renders the occluders as seen by the camera, shaded by the angle between the surface and the view
+// direction. Pixels not covered by any occluder are transparent.
+func (cam *camera) occluderLayer(occluders []Occluder, w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for py := 0; py < h; py++ {
+		for px := 0; px < w; px++ {
+			// Invert the projection at the pixel center.
+			u := (2.0*(float64(px)+0.5)/float64(w)-1.0)/cam.zoom + cam.pan[0]
+			v := (1.0-2.0*(float64(py)+0.5)/float64(h))/cam.zoom + cam.pan[1]
+			var o, d Vec3
+			if cam.tanHalfFOV > 0.0 {
+				o = cam.pos
+				d = cam.sx.Scale(u * cam.tanHalfFOV).Add(cam.sy.Scale(v * cam.tanHalfFOV)).Subtract(cam.sz)
+			} else {
+				// Start well behind the camera plane, orthographic cameras see the whole line of sight.
+				o = cam.pos.Add(cam.sx.Scale(u)).Add(cam.sy.Scale(v)).Add(cam.sz.Scale(1e3))
+				d = cam.sz.Scale(-1.0)
+			}
+			best, shade, c := math.Inf(1), 0.0, [3]float64{}
+			for _, occ := range occluders {
+				if t, n, ok := occ.Solid.intersect(o, d); ok && t < best {
+					best, c = t, occ.Color
+					shade = 0.3 + 0.7*math.Abs(n.Dot(d.Normalize()))
+				}
+			}
+			if !math.IsInf(best, 1) {
+				img.SetRGBA(px, py, color.RGBA{to8bit(c[0] * shade), to8bit(c[1] * shade), to8bit(c[2] * shade), 255})
+			}
+		}
+	}
+	return img
+}
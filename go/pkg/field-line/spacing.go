@@ -0,0 +1,153 @@
+package fieldline
+
+import (
+	"fmt"
+	"math"
+)
+
+// EvenSpacing configures evenly spaced streamline placement.
+type EvenSpacing struct {
+	// Target distance between adjacent lines in pixels.
+	Separation float64
+	// A line stops once it comes closer than Test*Separation to another line, defaults to 0.5.
+	Test float64
+	// Maximum number of lines to generate, unlimited if zero.
+	MaxLines int
+}
+
+// screenGrid buckets screen points into square cells for fast lookup of nearby line points.
+type screenGrid struct {
+	cell  float64
+	cols  int
+	rows  int
+	cells [][][2]float64
+}
+
+func newScreenGrid(cell float64, w, h int) *screenGrid {
+	cols := int(math.Ceil(float64(w)/cell)) + 1
+	rows := int(math.Ceil(float64(h)/cell)) + 1
+	return &screenGrid{
+		cell:  cell,
+		cols:  cols,
+		rows:  rows,
+		cells: make([][][2]float64, cols*rows),
+	}
+}
+
+func (g *screenGrid) index(x, y float64) (int, int, bool) {
+	i, j := int(math.Floor(x/g.cell)), int(math.Floor(y/g.cell))
+	return i, j, i >= 0 && i < g.cols && j >= 0 && j < g.rows
+}
+
+func (g *screenGrid) add(x, y float64) {
+	if i, j, ok := g.index(x, y); ok {
+		g.cells[j*g.cols+i] = append(g.cells[j*g.cols+i], [2]float64{x, y})
+	}
+}
+
+// near returns whether any point is within distance d of (x,y). d must not exceed the cell size.
+func (g *screenGrid) near(x, y, d float64) bool {
+	ci, cj, _ := g.index(x, y)
+	for j := cj - 1; j <= cj+1; j++ {
+		for i := ci - 1; i <= ci+1; i++ {
+			if i < 0 || i >= g.cols || j < 0 || j >= g.rows {
+				continue
+			}
+			for _, p := range g.cells[j*g.cols+i] {
+				dx, dy := p[0]-x, p[1]-y
+				if dx*dx+dy*dy < d*d {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// EvenlySpaced generates streamlines evenly separated on the screen, after Jobard and Lefer, "Creating
+// Evenly-Spaced Streamlines of Arbitrary Density" (1997).
+// Lines are traced in both directions, first from the given seeds in order, then from candidates placed at the
+// separation distance on either side of the lines already accepted. A line ends when it comes too close to another.
+// Generated trajectories inherit AtEnd from the seed they descend from and get random colors, symmetries of the
+// seeds are not applied.
+// Distances are measured with the first camera, so this is meant for planar scenes viewed head-on, e.g., the z=0
+// plane with the default camera. The returned trajectories are already traced and can be passed to Run with the same options directly.
+func EvenlySpaced(opts Options, seeds []Trajectory, es EvenSpacing) ([]Trajectory, error) {
+	if err := opts.validate(seeds); err != nil {
+		return nil, err
+	}
+	if !(es.Separation > 0.0) {
+		return nil, &OptionsError{Field: "Separation", Reason: fmt.Sprintf("must be positive, got %v", es.Separation)}
+	}
+	opts.setDefaults()
+	if es.Test <= 0.0 {
+		es.Test = 0.5
+	}
+	t := newTracer(&opts, true)
+	cam := opts.cameras[0]
+	w, h := opts.Width, opts.Height
+	grid := newScreenGrid(es.Separation, w, h)
+	dtest := es.Test * es.Separation
+	stop := func(p Vec3) bool {
+		x, y := cam.project(p, w, h)
+		return grid.near(x, y, dtest)
+	}
+
+	type candidate struct {
+		pos   Vec3
+		atEnd func(p, v Vec3) bool
+	}
+	var queue []candidate
+	for _, seed := range seeds {
+		queue = append(queue, candidate{pos: seed.Start, atEnd: seed.AtEnd})
+	}
+	var ret []Trajectory
+	for len(queue) > 0 && (es.MaxLines <= 0 || len(ret) < es.MaxLines) {
+		c := queue[0]
+		queue = queue[1:]
+		if x, y := cam.project(c.pos, w, h); grid.near(x, y, es.Separation) {
+			continue
+		}
+		traj := Trajectory{
+			Start:         c.pos,
+			AtEnd:         c.atEnd,
+			Color:         RandColor(),
+			Bidirectional: true,
+		}
+		// Tracing needs the identity symmetry, which Run adds again later.
+		traj.symmetries = []*Symmetry{newSymmetry(func(p Vec3) Vec3 { return p }, traj.Color)}
+		line := t.trace(&traj, stop)
+		traj.symmetries = nil
+		if len(line) < 2 {
+			continue
+		}
+		traj.line = line
+		key := t.keyOf(&traj)
+		traj.traced = &key
+		ret = append(ret, traj)
+
+		// Register the line, then queue candidate seeds on both sides every half separation along it.
+		for _, tp := range line {
+			grid.add(cam.project(tp.pos, w, h))
+		}
+		walked := es.Separation
+		x0, y0 := cam.project(line[0].pos, w, h)
+		for _, tp := range line[1:] {
+			x1, y1 := cam.project(tp.pos, w, h)
+			dx, dy := x1-x0, y1-y0
+			l := math.Hypot(dx, dy)
+			x0, y0 = x1, y1
+			walked += l
+			if l == 0.0 || walked < es.Separation/2.0 {
+				continue
+			}
+			walked = 0.0
+			nx, ny := -dy/l*es.Separation, dx/l*es.Separation
+			queue = append(queue,
+				candidate{pos: tp.pos.Add(cam.screenOffset(tp.pos, nx, ny, w, h)), atEnd: c.atEnd},
+				candidate{pos: tp.pos.Add(cam.screenOffset(tp.pos, -nx, -ny, w, h)), atEnd: c.atEnd},
+			)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,437 @@
+package fieldline
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// StopReason records why tracing of a trajectory ended in one direction.
+type StopReason int
+
+const (
+	// The trajectory has not been traced in this direction.
+	StopNone StopReason = iota
+	// AtEnd returned true.
+	StopAtEnd
+	// The trajectory left Options.Bounds, or when rendering, went out of bound for all cameras and symmetries.
+	StopOutOfBound
+	// Options.MaxSteps was reached.
+	StopMaxSteps
+	// Options.MaxArcLength was reached.
+	StopMaxArcLength
+	// The trajectory returned to within Options.LoopTolerance of its start.
+	StopClosedLoop
+	// The trajectory moved less than Options.StagnationTolerance over the last stagnationWindow steps.
+	StopStagnation
+	// The trajectory came too close to another line placed by EvenlySpaced.
+	StopNearLine
+	// The context of the run was done.
+	StopCancelled
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StopNone:
+		return "none"
+	case StopAtEnd:
+		return "at-end"
+	case StopOutOfBound:
+		return "out-of-bound"
+	case StopMaxSteps:
+		return "max-steps"
+	case StopMaxArcLength:
+		return "max-arc-length"
+	case StopClosedLoop:
+		return "closed-loop"
+	case StopStagnation:
+		return "stagnation"
+	case StopNearLine:
+		return "near-line"
+	case StopCancelled:
+		return "cancelled"
+	}
+	return fmt.Sprintf("StopReason(%d)", int(r))
+}
+
+// MarshalText encodes the reason by its name, e.g., in JSON exports.
+func (r StopReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Number of steps over which stagnation is measured.
+const stagnationWindow = 64
+
+// A traced point in world space.
+type tracePoint struct {
+	pos           Vec3
+	tangentLength float64
+	// Colormap scalar, if any.
+	scalar float64
+}
+
+// Bounds is the world space box [Min,Max] on each axis.
+type Bounds struct {
+	Min Vec3
+	Max Vec3
+}
+
+func (b Bounds) valid() bool {
+	return b.Min[0] < b.Max[0] && b.Min[1] < b.Max[1] && b.Min[2] < b.Max[2]
+}
+
+func (b Bounds) contains(p Vec3) bool {
+	for i := range p {
+		if p[i] < b.Min[i] || p[i] > b.Max[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// traceKey is what a traced line depends on in the options of a run, besides the tangent field.
+type traceKey struct {
+	// Rendering decimates lines to the pixels of the cameras and stops them once off screen.
+	render        bool
+	width, height int
+	// Copies, since SetView changes the cameras in place.
+	cameras       []camera
+	step          float64
+	integrator    Integrator
+	arcLength     bool
+	maxSteps      int
+	maxArcLength  float64
+	loopTol       float64
+	stagnationTol float64
+	bounds        *Bounds
+}
+
+// lineKey is what a traced line depends on besides the tangent field, so that trajectories changed or traced with
+// other options since are traced again rather than reused.
+type lineKey struct {
+	traceKey
+	start         Vec3
+	bidirectional bool
+	// AtEnd by identity, since functions cannot be compared.
+	atEnd uintptr
+	// Symmetries added with AddSymmetry, compared by pointer, which decimation to pixels depends on.
+	symmetries []*Symmetry
+}
+
+// tracer traces trajectories with the shared settings of a run.
+type tracer struct {
+	opts *Options
+	key  traceKey
+	// The field being integrated, which is the normalized tangent for arc length tracing.
+	direction func(Vec3) Vec3
+	// Tracing stops early once done is closed, never if nil.
+	done <-chan struct{}
+}
+
+func newTracer(opts *Options, render bool) *tracer {
+	key := traceKey{
+		render:        render,
+		step:          opts.Step,
+		integrator:    opts.Integrator,
+		arcLength:     opts.ArcLength,
+		maxSteps:      opts.MaxSteps,
+		maxArcLength:  opts.MaxArcLength,
+		loopTol:       opts.LoopTolerance,
+		stagnationTol: opts.StagnationTolerance,
+		bounds:        opts.Bounds,
+	}
+	if render {
+		key.width, key.height = opts.Width, opts.Height
+		for _, cam := range opts.cameras {
+			key.cameras = append(key.cameras, *cam)
+		}
+	}
+	t := &tracer{opts: opts, key: key, direction: opts.TangentAt}
+	if opts.ArcLength {
+		t.direction = unitTangent(opts.TangentAt)
+	}
+	return t
+}
+
+// keyOf returns the key of the line of traj traced by t.
+func (t *tracer) keyOf(traj *Trajectory) lineKey {
+	syms := traj.symmetries
+	if traj.withIdentity {
+		syms = syms[1:]
+	}
+	key := lineKey{
+		traceKey:      t.key,
+		start:         traj.Start,
+		bidirectional: traj.Bidirectional,
+		symmetries:    append([]*Symmetry(nil), syms...),
+	}
+	if traj.AtEnd != nil {
+		key.atEnd = reflect.ValueOf(traj.AtEnd).Pointer()
+	}
+	return key
+}
+
+// traceAll traces in parallel all trajectories not yet traced with the same options, after prepending the identity
+// symmetry.
+func traceAll(ctx context.Context, opts *Options, trajs []Trajectory, render bool) error {
+	t := newTracer(opts, render)
+	t.done = ctx.Done()
+	var wg sync.WaitGroup
+	wg.Add(len(trajs))
+	for i := range trajs {
+		go func(traj *Trajectory) {
+			defer wg.Done()
+			if !traj.withIdentity {
+				identity := newSymmetry(func(p Vec3) Vec3 { return p }, traj.Color)
+				traj.symmetries = append([]*Symmetry{identity}, traj.symmetries...)
+				traj.withIdentity = true
+			}
+			// Deep comparison, since the integrator and Bounds may be pointers to equal values.
+			key := t.keyOf(traj)
+			if traj.traced != nil && reflect.DeepEqual(*traj.traced, key) {
+				return
+			}
+			traj.line = t.trace(traj, nil)
+			traj.traced = &key
+			// A line cut short by cancellation is traced again by the next run.
+			if traj.forwardStop == StopCancelled || traj.backwardStop == StopCancelled {
+				traj.traced = nil
+			}
+		}(&trajs[i])
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Polyline is a traced trajectory in world space, as seen through one of its symmetries.
+type Polyline struct {
+	// Index of the trajectory in the slice passed to Trace.
+	Trajectory int `json:"trajectory"`
+	// Index of the symmetry, 0 being the identity followed by those added with AddSymmetry in order.
+	Symmetry int `json:"symmetry"`
+	// Points along the field direction, one per integration step.
+	Points []Vec3 `json:"points"`
+	// Magnitude of the tangent field at each point.
+	TangentLengths []float64 `json:"tangentLengths"`
+	// Color of the symmetry.
+	Color [3]float64 `json:"color"`
+	// Why tracing ended along and against the field direction.
+	ForwardStop  StopReason `json:"forwardStop"`
+	BackwardStop StopReason `json:"backwardStop"`
+}
+
+// Trace traces the trajectories without rendering, returning one polyline per trajectory and symmetry. The lines do
+// not depend on Width, Height or the cameras, and stop at Bounds rather than at the edge of the screen.
+// As with Run, trajs internal data structure would have been modified, and trajectories already traced with the same
+// options are reused.
+func Trace(opts Options, trajs []Trajectory) ([]Polyline, error) {
+	return TraceContext(context.Background(), opts, trajs)
+}
+
+// TraceContext is like Trace, but stops once ctx is done, in which case ctx.Err() is returned.
+func TraceContext(ctx context.Context, opts Options, trajs []Trajectory) ([]Polyline, error) {
+	if err := opts.validateTracing(trajs); err != nil {
+		return nil, err
+	}
+	if opts.Bounds == nil && opts.MaxSteps == 0 && opts.MaxArcLength == 0.0 {
+		return nil, &OptionsError{Field: "Bounds", Reason: "must be set unless MaxSteps or MaxArcLength is"}
+	}
+	opts.setDefaults()
+	if err := traceAll(ctx, &opts, trajs, false); err != nil {
+		return nil, err
+	}
+	var ret []Polyline
+	for i := range trajs {
+		traj := &trajs[i]
+		for s, sym := range traj.symmetries {
+			pl := Polyline{
+				Trajectory:     i,
+				Symmetry:       s,
+				Points:         make([]Vec3, len(traj.line)),
+				TangentLengths: make([]float64, len(traj.line)),
+				Color:          sym.color,
+				ForwardStop:    traj.forwardStop,
+				BackwardStop:   traj.backwardStop,
+			}
+			for k, tp := range traj.line {
+				pl.Points[k] = sym.transform(tp.pos)
+				pl.TangentLengths[k] = tp.tangentLength
+			}
+			ret = append(ret, pl)
+		}
+	}
+	return ret, nil
+}
+
+// unitTangent returns the normalized direction field of f, leaving zero vectors as is.
+func unitTangent(f func(Vec3) Vec3) func(Vec3) Vec3 {
+	return func(p Vec3) Vec3 {
+		v := f(p)
+		if n := v.Norm(); n > 0.0 {
+			return v.Scale(1.0 / n)
+		}
+		return v
+	}
+}
+
+// trace traces the trajectory from its start, in both directions if requested, and records why each direction
+// ended. The returned line always runs along the field direction. If stop is not nil, tracing also ends at the first
+// position it returns true for.
+func (t *tracer) trace(traj *Trajectory, stop func(Vec3) bool) []tracePoint {
+	fwd, reason := t.traceHalf(traj, 1.0, stop)
+	traj.forwardStop, traj.backwardStop = reason, StopNone
+	if !traj.Bidirectional {
+		return fwd
+	}
+	// Tracing backwards would only run the same loop again.
+	if reason == StopClosedLoop {
+		traj.backwardStop = StopClosedLoop
+		return fwd
+	}
+	bwd, reason := t.traceHalf(traj, -1.0, stop)
+	traj.backwardStop = reason
+	line := make([]tracePoint, 0, len(bwd)+len(fwd))
+	for i := len(bwd) - 1; i >= 0; i-- {
+		line = append(line, bwd[i])
+	}
+	// Both halves share the start point.
+	if len(bwd) > 0 && len(fwd) > 0 {
+		fwd = fwd[1:]
+	}
+	return append(line, fwd...)
+}
+
+// traceHalf traces the trajectory along (sign=1) or against (sign=-1) the tangent field until AtEnd is reached,
+// the trajectory leaves Bounds, or one of the runaway guards in Options trips.
+// AtEnd sees the tangent in the direction of travel.
+// When rendering, tracing also stops once the trajectory goes out of bound for all cameras and symmetries, and a
+// point is kept only if it lands on a new pixel for at least one camera and symmetry.
+func (t *tracer) traceHalf(traj *Trajectory, sign float64, stop func(Vec3) bool) ([]tracePoint, StopReason) {
+	opts := t.opts
+	direction := t.direction
+	if sign < 0.0 {
+		direction = func(p Vec3) Vec3 { return t.direction(p).Scale(-1.0) }
+	}
+	var last []pixel
+	if t.key.render {
+		last = make([]pixel, len(opts.cameras)*len(traj.symmetries))
+	}
+	var ret []tracePoint
+	x := traj.Start
+	h := opts.Step
+	steps := 0
+	arcLength := 0.0
+	// The loop check is armed only once the trajectory has left the neighborhood of its start.
+	leftStart := false
+	var window [stagnationWindow]Vec3
+	for ; ; steps++ {
+		select {
+		case <-t.done:
+			return ret, StopCancelled
+		default:
+		}
+		a := opts.TangentAt(x).Scale(sign)
+		if traj.AtEnd(x, a) {
+			return ret, StopAtEnd
+		}
+		if stop != nil && stop(x) {
+			return ret, StopNearLine
+		}
+		if opts.LoopTolerance > 0.0 {
+			d := x.Subtract(traj.Start).Norm()
+			if !leftStart && d > 2.0*opts.LoopTolerance {
+				leftStart = true
+			}
+			if leftStart && d < opts.LoopTolerance {
+				// Close the loop exactly.
+				return append(ret, tracePoint{pos: traj.Start, tangentLength: a.Norm()}), StopClosedLoop
+			}
+		}
+		if opts.StagnationTolerance > 0.0 {
+			if steps >= stagnationWindow && x.Subtract(window[steps%stagnationWindow]).Norm() < opts.StagnationTolerance {
+				return ret, StopStagnation
+			}
+			window[steps%stagnationWindow] = x
+		}
+		if opts.MaxSteps > 0 && steps >= opts.MaxSteps {
+			return ret, StopMaxSteps
+		}
+		if opts.MaxArcLength > 0.0 && arcLength >= opts.MaxArcLength {
+			return ret, StopMaxArcLength
+		}
+		if opts.Bounds != nil && !opts.Bounds.contains(x) {
+			return ret, StopOutOfBound
+		}
+		tanlen := a.Norm()
+		keep := true
+		if t.key.render {
+			allOutOfBound := true
+			keep = len(ret) == 0
+			for c, camera := range opts.cameras {
+				for s, sym := range traj.symmetries {
+					idx := c*len(traj.symmetries) + s
+					px := camera.worldToScreen(sym.transform(x), opts.Width, opts.Height)
+					if px.inBound(opts.Width, opts.Height) {
+						allOutOfBound = false
+					}
+					if px != last[idx] {
+						keep = true
+					}
+					last[idx] = px
+				}
+			}
+			if allOutOfBound {
+				return ret, StopOutOfBound
+			}
+		}
+		if keep {
+			ret = append(ret, tracePoint{pos: x, tangentLength: tanlen})
+		}
+
+		if opts.ArcLength && tanlen > 0.0 {
+			a = a.Scale(1.0 / tanlen)
+		}
+		next, nextH := opts.Integrator.Advance(direction, x, a, h)
+		arcLength += next.Subtract(x).Norm()
+		x, h = next, nextH
+	}
+}
+
+// project projects the traced line for every camera and symmetry, recording the depth of each point and whether an
+// occluder hides it. Consecutive points landing on the same pixel are included only once, unless the point moves in
+// or out of hiding so that the line ends exactly at the occluder boundary.
+func (traj *Trajectory) project(opts *Options) {
+	traj.maxTanLen = -1.0
+	traj.minTanLen = -1.0
+	traj.points = make([][]trajPoint, len(opts.cameras)*len(traj.symmetries))
+	for _, tp := range traj.line {
+		if traj.maxTanLen < 0.0 || traj.maxTanLen < tp.tangentLength {
+			traj.maxTanLen = tp.tangentLength
+		}
+		if traj.minTanLen < 0.0 || traj.minTanLen > tp.tangentLength {
+			traj.minTanLen = tp.tangentLength
+		}
+		for c, camera := range opts.cameras {
+			for s, sym := range traj.symmetries {
+				idx := c*len(traj.symmetries) + s
+				p := sym.transform(tp.pos)
+				pt := trajPoint{
+					tangentLength: tp.tangentLength,
+					scalar:        tp.scalar,
+					pixel:         camera.worldToScreen(p, opts.Width, opts.Height),
+					depth:         float32(camera.depth(p)),
+					hidden:        len(opts.Occluders) > 0 && camera.hidden(p, opts.Occluders),
+				}
+				pt.x, pt.y = camera.project(p, opts.Width, opts.Height)
+				if n := len(traj.points[idx]); n > 0 {
+					last := traj.points[idx][n-1]
+					if last.pixel == pt.pixel && last.hidden == pt.hidden {
+						continue
+					}
+				}
+				traj.points[idx] = append(traj.points[idx], pt)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"math"
+
+	fieldline "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-line"
+	fieldplot "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-plot"
+	fieldrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-renderer"
+)
+
+var (
+	heatmap  = flag.String("heatmap", "", "heatmap file")
+	output   = flag.String("output", "", "output file")
+	gamma    = flag.Float64("gamma", 1.0, "gamma correction")
+	width    = flag.Int("width", 800, "output width")
+	height   = flag.Int("height", 800, "output height")
+	step     = flag.Float64("step", 0.005, "field line step")
+	contours = flag.Int("contours", 20, "number of equipotential contours")
+)
+
+// Conducting plane at z=0 with a circular hole of radius a, section 3.13. Coordinates are (rho,z) in the x-y plane.
+const (
+	a  = 0.35
+	e0 = 1.0
+	e1 = 0.2
+)
+
+func potential(rho, z float64) float64 {
+	l := (z*z + rho*rho - a*a) / (a * a)
+	r := math.Sqrt(l*l + 4*z*z/(a*a))
+	v1 := math.Sqrt((r - l) / 2)
+	v2 := math.Abs(z) / a * math.Atan(math.Sqrt(2/(r+l)))
+	ret := (e0 - e1) * a / math.Pi * (v1 - v2)
+	if z > 0 {
+		ret += e0 * z
+	} else {
+		ret += e1 * z
+	}
+	return ret
+}
+
+func main() {
+	flag.Parse()
+
+	// E=-grad(potential) by central differences.
+	field := func(p fieldline.Vec3) fieldline.Vec3 {
+		const d = 1e-6
+		return fieldline.Vec3{
+			-(potential(p[0]+d, p[1]) - potential(p[0]-d, p[1])) / (2 * d),
+			-(potential(p[0], p[1]+d) - potential(p[0], p[1]-d)) / (2 * d),
+			0,
+		}
+	}
+	atEnd := func(p, v fieldline.Vec3) bool {
+		return v.Norm() < 1e-2
+	}
+	var trajs []fieldline.Trajectory
+	for i := 0; i < 41; i++ {
+		trajs = append(trajs, fieldline.Trajectory{
+			Start:         fieldline.Vec3{-1 + float64(i)*0.05, 0.9, 0},
+			AtEnd:         atEnd,
+			Color:         [3]float64{1, 1, 1},
+			Bidirectional: true,
+		})
+	}
+
+	if err := fieldplot.Run(fieldplot.Options{
+		OutputFile:   *output,
+		Width:        *width,
+		Height:       *height,
+		Viewport:     fieldrenderer.Viewport{XMin: -1, XMax: 1, YMin: -1, YMax: 1},
+		Potential:    potential,
+		HeatMapFile:  *heatmap,
+		Gamma:        *gamma,
+		Contours:     *contours,
+		ContourColor: [3]float64{0, 0, 0},
+		ContourWidth: 1,
+		Lines:        trajs,
+		LineOptions: fieldline.Options{
+			Step:        *step,
+			TangentAt:   field,
+			LineWidth:   1.5,
+			FadingGamma: 0.5,
+			ArcLength:   true,
+		},
+	}); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,148 @@
+package fieldplot
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+
+	fieldline "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-line"
+	fieldrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-renderer"
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
+	"github.com/fogleman/gg"
+)
+
+// Options represents options to plot a potential map with field lines and equipotential contours, all in the same
+// world coordinates.
+type Options struct {
+	OutputFile string
+	// If set, the plot is written to the sink as frame 0 instead of being saved to OutputFile.
+	Sink   framesink.FrameSink
+	Width  int
+	Height int
+	// World rectangle shown on the plot.
+	Viewport fieldrenderer.Viewport
+
+	// Potential drawn through the heatmap as background, black background if nil.
+	Potential   func(x, y float64) float64
+	HeatMapFile string
+	Gamma       float64
+	// How the potential is mapped onto the heatmap, see fieldrenderer.Options.
+	Normalization *fieldrenderer.Normalization
+
+	// Equipotential contours of Potential at the given levels, or at Contours levels evenly spaced across its range
+	// if no levels are given.
+	ContourLevels []float64
+	Contours      int
+	ContourColor  [3]float64
+	ContourWidth  float64
+
+	// Field lines traced in the z=0 plane with LineOptions, whose Bounds is set to the plot and whose rendering
+	// options such as OutputFile, Sink, Width, Height and CameraOrbit are ignored. Unless set, LoopTolerance and
+	// StagnationTolerance default to a pixel and MaxArcLength to 100 times the diagonal of the plot.
+	Lines       []fieldline.Trajectory
+	LineOptions fieldline.Options
+}
+
+// Run renders the plot into a PNG image, or into the sink if set.
+func Run(opts Options) error {
+	m, err := opts.Viewport.Map(opts.Width, opts.Height)
+	if err != nil {
+		return err
+	}
+	if opts.Potential == nil && (len(opts.ContourLevels) > 0 || opts.Contours > 0) {
+		return fmt.Errorf("contours need a potential")
+	}
+
+	dc := gg.NewContext(opts.Width, opts.Height)
+	dc.SetRGB(0, 0, 0)
+	dc.Clear()
+
+	if opts.Potential != nil {
+		res, err := fieldrenderer.Render(fieldrenderer.Options{
+			HeatMapFile:   opts.HeatMapFile,
+			Gamma:         opts.Gamma,
+			Width:         opts.Width,
+			Height:        opts.Height,
+			WorldField:    opts.Potential,
+			Viewport:      &opts.Viewport,
+			Normalization: opts.Normalization,
+			ContourLevels: opts.ContourLevels,
+			Contours:      opts.Contours,
+			ContourColor: color.RGBA64{
+				R: uint16(opts.ContourColor[0] * 0xffff),
+				G: uint16(opts.ContourColor[1] * 0xffff),
+				B: uint16(opts.ContourColor[2] * 0xffff),
+				A: 0xffff,
+			},
+			ContourWidth: opts.ContourWidth,
+		})
+		if err != nil {
+			return err
+		}
+		dc.DrawImage(res.Image, 0, 0)
+	}
+
+	if len(opts.Lines) > 0 {
+		if err := drawLines(dc, opts, m); err != nil {
+			return err
+		}
+	}
+
+	if opts.Sink != nil {
+		return opts.Sink.Write(framesink.Frame{Image: dc.Image()})
+	}
+	if err := dc.SavePNG(opts.OutputFile); err != nil {
+		return fmt.Errorf("failed to save %v: %v", opts.OutputFile, err)
+	}
+	return nil
+}
+
+// drawLines traces the field lines and draws them faded by the field magnitude as fieldline.Run does.
+func drawLines(dc *gg.Context, opts Options, m *fieldrenderer.Mapping) error {
+	lo := opts.LineOptions
+	diagonal := math.Hypot(m.XMax-m.XMin, m.YMax-m.YMin)
+	// Tracing stops where lines leave the plot, in a slab around the z=0 plane as thick as the plot is wide.
+	lo.Bounds = &fieldline.Bounds{
+		Min: fieldline.Vec3{m.XMin, m.YMin, -diagonal / 2.0},
+		Max: fieldline.Vec3{m.XMax, m.YMax, diagonal / 2.0},
+	}
+	// Lines closing or stalling within a pixel look the same as those traced on, and those running longer than
+	// many times across the plot are not going to leave it.
+	pixel := math.Max((m.XMax-m.XMin)/float64(m.Width), (m.YMax-m.YMin)/float64(m.Height))
+	if lo.LoopTolerance == 0.0 {
+		lo.LoopTolerance = pixel
+	}
+	if lo.StagnationTolerance == 0.0 {
+		lo.StagnationTolerance = pixel
+	}
+	if lo.MaxArcLength == 0.0 {
+		lo.MaxArcLength = 100.0 * diagonal
+	}
+	lines, err := fieldline.Trace(lo, opts.Lines)
+	if err != nil {
+		return err
+	}
+
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, line := range lines {
+		for _, t := range line.TangentLengths {
+			min, max = math.Min(min, t), math.Max(max, t)
+		}
+	}
+	if !(max > min) {
+		min, max = 0.0, 1.0
+	}
+	dc.SetLineWidth(lo.LineWidth)
+	for _, line := range lines {
+		for k := 0; k+1 < len(line.Points); k++ {
+			avg := (line.TangentLengths[k] + line.TangentLengths[k+1]) / 2.0
+			alpha := math.Pow((avg-min)/(max-min), lo.FadingGamma)
+			dc.SetRGBA(line.Color[0], line.Color[1], line.Color[2], alpha)
+			x0, y0 := m.Pixel(line.Points[k][0], line.Points[k][1])
+			x1, y1 := m.Pixel(line.Points[k+1][0], line.Points[k+1][1])
+			dc.DrawLine(x0, y0, x1, y1)
+			dc.Stroke()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package fieldrenderer
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
+)
+
+// Animation represents options to render a numbered sequence of frames normalized together, so that the brightness
+// does not flicker from frame to frame.
+type Animation struct {
+	// Options shared by all frames. Field, SubpixelField, WorldField, OutputFile, Sink and PostEdit are ignored, and
+	// Progress reports each frame as its field is evaluated.
+	Options Options
+	Frames  int
+	// Field function of the frame at world coordinates of the Viewport if set, or else at continuous pixel
+	// coordinates as SubpixelField. Return math.NaN to indicate divergence.
+	Field func(frame int, x, y float64) float64
+	// Output file name formatted with the frame number, e.g., "/tmp/sweep-%03d.png".
+	OutputPattern string
+	// If set, the frames are written to the sink along with their field values instead of being saved with
+	// OutputPattern.
+	Sink framesink.FrameSink
+	// If set and Sink is not, the frames are encoded into this .gif or .png (APNG) file at FrameRate instead of being
+	// saved with OutputPattern, the GIF frames quantized to the heatmap.
+	AnimationFile string
+	FrameRate     float64
+	// Number of frames on each side of a frame whose ranges of field values are combined to normalize it, for fields
+	// fading in or out over the sequence. All frames are combined if 0.
+	Window int
+	// Function to edit the image of a frame after it has rendered, before the color bar is drawn over it.
+	PostEdit func(frame int, img draw.Image)
+}
+
+// RunAnimation renders the frames of the animation. The field values of all frames are evaluated first and spilled
+// to a temporary file, then the frames are colored with their combined ranges and saved.
+func RunAnimation(a Animation) error {
+	return RunAnimationContext(context.Background(), a)
+}
+
+// RunAnimationContext is RunAnimation stopping early with the context error if the context is done.
+func RunAnimationContext(ctx context.Context, a Animation) error {
+	if a.Frames <= 0 {
+		return fmt.Errorf("invalid frame count %v", a.Frames)
+	}
+	if a.Field == nil {
+		return fmt.Errorf("missing field function")
+	}
+	if a.Window < 0 {
+		return fmt.Errorf("invalid window %v", a.Window)
+	}
+	opts := a.Options
+	opts.Field, opts.SubpixelField, opts.WorldField = nil, nil, nil
+	r, err := newRenderer(opts)
+	if err != nil {
+		return err
+	}
+
+	sink := a.Sink
+	switch {
+	case sink != nil:
+	case a.AnimationFile != "":
+		if sink, err = framesink.NewAnimationFile(a.AnimationFile, a.FrameRate, r.hm); err != nil {
+			return err
+		}
+		if err := a.render(ctx, r, sink); err != nil {
+			sink.Abort()
+			return err
+		}
+		return sink.Close()
+	default:
+		if err := checkPattern(a.OutputPattern); err != nil {
+			return err
+		}
+		sink = framesink.NewPNG(a.OutputPattern)
+	}
+	return a.render(ctx, r, sink)
+}
+
+// render evaluates and paints the frames, writing them to the sink.
+func (a *Animation) render(ctx context.Context, r *renderer, sink framesink.FrameSink) error {
+	opts := r.opts
+	spill, err := os.CreateTemp("", "fieldrenderer-*.data")
+	if err != nil {
+		return fmt.Errorf("failed to create spill file: %v", err)
+	}
+	defer os.Remove(spill.Name())
+	defer spill.Close()
+
+	// Evaluate the frames, keeping the range of each.
+	los, his := make([]float64, a.Frames), make([]float64, a.Frames)
+	for f := 0; f < a.Frames; f++ {
+		fopts := opts
+		field := func(x, y float64) float64 { return a.Field(f, x, y) }
+		if opts.Viewport != nil {
+			fopts.WorldField = field
+		} else {
+			fopts.SubpixelField = field
+		}
+		data, err := r.sample(ctx, fopts)
+		if err != nil {
+			return err
+		}
+		los[f], his[f] = r.norm.dataRange(data)
+		if err := binary.Write(spill, binary.LittleEndian, data); err != nil {
+			return fmt.Errorf("failed to spill frame %v: %v", f, err)
+		}
+	}
+
+	if _, err := spill.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind spill file: %v", err)
+	}
+	data := make([]float64, opts.Width*opts.Height)
+	for f := 0; f < a.Frames; f++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := binary.Read(spill, binary.LittleEndian, data); err != nil {
+			return fmt.Errorf("failed to read back frame %v: %v", f, err)
+		}
+		first, last := 0, a.Frames-1
+		if a.Window > 0 {
+			first, last = max(f-a.Window, 0), min(f+a.Window, a.Frames-1)
+		}
+		lo, hi := math.NaN(), math.NaN()
+		for k := first; k <= last; k++ {
+			if math.IsNaN(lo) || los[k] < lo {
+				lo = los[k]
+			}
+			if math.IsNaN(hi) || his[k] > hi {
+				hi = his[k]
+			}
+		}
+		lo, hi = r.norm.adjust(lo, hi)
+		res := r.paint(data, lo, hi)
+		if a.PostEdit != nil {
+			a.PostEdit(f, res.Image)
+		}
+		// The color bar goes over whatever PostEdit draws.
+		if err := r.drawColorBar(res.Image, lo, hi); err != nil {
+			return err
+		}
+		if err := sink.Write(framesink.Frame{Index: f, Image: res.Image, Data: data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPattern checks that the output file name pattern formats exactly one integer, the frame number.
+func checkPattern(pattern string) error {
+	verbs := 0
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+		// Skip the flags, width and precision.
+		i++
+		for i < len(pattern) && strings.IndexByte("+-# 0123456789.", pattern[i]) >= 0 {
+			i++
+		}
+		if i == len(pattern) {
+			return fmt.Errorf("invalid output pattern '%v': incomplete verb", pattern)
+		}
+		if pattern[i] == '%' {
+			continue
+		}
+		if strings.IndexByte("bdoOxXv", pattern[i]) < 0 {
+			return fmt.Errorf("invalid output pattern '%v': %%%c does not format the frame number", pattern, pattern[i])
+		}
+		verbs++
+	}
+	if verbs != 1 {
+		return fmt.Errorf("invalid output pattern '%v': expected exactly one verb for the frame number", pattern)
+	}
+	return nil
+}
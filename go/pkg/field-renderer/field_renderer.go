@@ -1,88 +1,206 @@
 package fieldrenderer
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"image/png"
 	"math"
-	"os"
-	"runtime"
-	"strings"
-	"sync"
-	"sync/atomic"
+	"time"
 
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
+	"github.com/euphoricrhino/jackson-em-notes/go/pkg/contour"
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
 	"github.com/euphoricrhino/jackson-em-notes/go/pkg/heatmap"
+	"github.com/fogleman/gg"
 )
 
 // Options represents options to run the field renderer.
 type Options struct {
 	HeatMapFile string
 	OutputFile  string
+	// If set, the image is written to the sink as frame 0 along with the field values instead of being saved to
+	// OutputFile.
+	Sink framesink.FrameSink
 	// Gamma correction to be applied to heatmap.
 	Gamma  float64
 	Width  int
 	Height int
 	// Field function for pixel (x,y) ranging from 0 to (Width|Height)-1. Return math.NaN to indicate divergence.
 	Field func(x, y int) float64
+	// Field function at continuous pixel coordinates, where pixel (x,y) covers [x,x+1)x[y,y+1). Used instead of
+	// Field if set, which is required for supersampling.
+	SubpixelField func(x, y float64) float64
+	// Number of stratified samples per pixel along each axis, e.g., 4 for 4x4 supersampling. Samples are averaged
+	// before colormapping, skipping NaNs. Defaults to 1, i.e., sampling at pixel centers.
+	Samples int
+	// Field function at world coordinates (x,y) of the Viewport, used instead of Field and SubpixelField if set.
+	// Supersampled with Samples as SubpixelField is.
+	WorldField func(x, y float64) float64
+	// World rectangle shown on the image, required by WorldField and Axes.
+	Viewport *Viewport
+	// Ticks and labels of the world coordinates, drawn over the contours if set.
+	Axes *Axes
+	// Color bar legend drawn over the image if set. Its Heatmap, Min and Max are filled in with the heatmap and the
+	// range mapped onto it, i.e., Result.Low and Result.High.
+	ColorBar *colorbar.Options
+	// How field values are mapped onto the heatmap, defaults to linearly from the minimum to the maximum.
+	Normalization *Normalization
+	// Color of divergent pixels, defaults to the low end of the heatmap.
+	NaNColor color.Color
+	// Number of goroutines evaluating the field, defaults to the number of CPUs.
+	Workers int
+	// Side of the square tiles of pixels the workers pull off the queue, defaults to 32.
+	TileSize int
+	// Called periodically with the rendering progress if set, and once when all pixels are rendered.
+	Progress Progress
+	// Period of the progress reports, defaults to 200ms.
+	ProgressInterval time.Duration
 	// Function to edit the generated image after all the field pixels have rendered.
 	PostEdit func(img draw.Image)
+
+	// Contour lines of the field at the given levels, or at Contours levels evenly spaced across its range if no
+	// levels are given.
+	ContourLevels []float64
+	Contours      int
+	// Color and width of the contour lines, default to black and 1 pixel.
+	ContourColor color.Color
+	ContourWidth float64
+}
+
+// Contour is the set of contour lines at a level, in pixel coordinates.
+type Contour struct {
+	Level float64
+	Lines [][][2]float64
+}
+
+// Result is a rendered field.
+type Result struct {
+	Image *image.RGBA
+	// Field values before normalization, row-major, NaN where divergent.
+	Data []float64
+	// Range of the non-NaN field values, NaN if all values are NaN.
+	Min float64
+	Max float64
+	// Range of scaled values mapped onto the heatmap, e.g., in decibels with ScaleDecibels.
+	Low  float64
+	High float64
+	// Contour lines drawn on the image, if requested.
+	Contours []Contour
+	// Mapping between pixel and world coordinates, nil without a Viewport.
+	Mapping *Mapping
 }
 
 // Run runs the field renderer with the given options.
 func Run(opts Options) error {
-	hm, err := heatmap.Load(opts.HeatMapFile, opts.Gamma)
+	return RunContext(context.Background(), opts)
+}
+
+// RunContext is Run stopping early with the context error if the context is done.
+func RunContext(ctx context.Context, opts Options) error {
+	r, res, err := render(ctx, opts)
 	if err != nil {
 		return err
 	}
+	if opts.PostEdit != nil {
+		opts.PostEdit(res.Image)
+	}
+	// The color bar goes over whatever PostEdit draws, as with RunAnimation.
+	if err := r.drawColorBar(res.Image, res.Low, res.High); err != nil {
+		return err
+	}
+	if opts.Sink != nil {
+		return opts.Sink.Write(framesink.Frame{Image: res.Image, Data: res.Data})
+	}
+	return framesink.SavePNG(opts.OutputFile, res.Image)
+}
 
-	data := make([]float64, opts.Width*opts.Height)
-	workers := runtime.NumCPU()
-	var wg sync.WaitGroup
-	wg.Add(workers)
-	cnt := int32(0)
-	for w := 0; w < workers; w++ {
-		go func(i int) {
-			defer wg.Done()
-			for x := 0; x < opts.Width; x++ {
-				if x%workers != i {
-					continue
-				}
-				for y := 0; y < opts.Height; y++ {
-					data[y*opts.Width+x] = opts.Field(x, y)
-					atomic.AddInt32(&cnt, 1)
-				}
-			}
-		}(w)
-	}
-	// Progress counter.
-	counterDone := make(chan struct{})
-	go func() {
-		erase := strings.Repeat(" ", 80)
-		nextMark := 1.0
-		for {
-			doneCnt := int(atomic.LoadInt32(&cnt))
-			if doneCnt == opts.Width*opts.Height {
-				fmt.Printf("\r%v\rrendering complete\n", erase)
-				close(counterDone)
-				return
-			}
-			progress := float64(doneCnt) / float64(opts.Width*opts.Height) * 100.0
-			if progress >= nextMark {
-				fmt.Printf("\r%v\rrendering... %.2f%% done", erase, progress)
-				nextMark = math.Ceil(progress)
-			}
-			runtime.Gosched()
+// Render renders the field into an image without saving it. PostEdit, OutputFile and Sink are ignored.
+func Render(opts Options) (*Result, error) {
+	return RenderContext(context.Background(), opts)
+}
+
+// RenderContext is Render stopping early with the context error if the context is done.
+func RenderContext(ctx context.Context, opts Options) (*Result, error) {
+	r, res, err := render(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.drawColorBar(res.Image, res.Low, res.High); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// render samples and paints the field, leaving the color bar to the caller.
+func render(ctx context.Context, opts Options) (*renderer, *Result, error) {
+	r, err := newRenderer(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := r.sample(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	lo, hi := r.norm.adjust(r.norm.dataRange(data))
+	return r, r.paint(data, lo, hi), nil
+}
+
+// renderer holds what is shared by the images rendered with the same options.
+type renderer struct {
+	opts     Options
+	m        *Mapping
+	norm     *Normalization
+	hm       []color.Color
+	nanColor color.Color
+}
+
+func newRenderer(opts Options) (*renderer, error) {
+	r := &renderer{opts: opts, norm: opts.Normalization, nanColor: opts.NaNColor}
+	if opts.Viewport != nil {
+		var err error
+		if r.m, err = opts.Viewport.Map(opts.Width, opts.Height); err != nil {
+			return nil, err
 		}
-	}()
-	wg.Wait()
-	<-counterDone
+	} else if opts.WorldField != nil || opts.Axes != nil {
+		return nil, fmt.Errorf("world coordinates require a viewport")
+	}
+	if r.norm == nil {
+		r.norm = &Normalization{}
+	}
+	if err := r.norm.Validate(); err != nil {
+		return nil, err
+	}
+	var err error
+	if r.hm, err = heatmap.Load(opts.HeatMapFile, opts.Gamma); err != nil {
+		return nil, err
+	}
+	if r.nanColor == nil {
+		r.nanColor = r.hm[0]
+	}
+	return r, nil
+}
 
-	// Normalize the data.
+// sample evaluates the field functions of opts at every pixel, row-major.
+func (r *renderer) sample(ctx context.Context, opts Options) ([]float64, error) {
+	sample, err := sampler(opts, r.m)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]float64, opts.Width*opts.Height)
+	if err := renderTiles(ctx, opts, sample, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// paint colors the raw field values with the range [lo,hi] of scaled values mapped onto the heatmap, and draws the
+// contours and axes over the image.
+func (r *renderer) paint(raw []float64, lo, hi float64) *Result {
+	opts := r.opts
 	max, min := math.NaN(), math.NaN()
-	for i := 0; i < len(data); i++ {
-		v := data[i]
+	for _, v := range raw {
 		if !math.IsNaN(v) {
 			if math.IsNaN(max) || max < v {
 				max = v
@@ -92,50 +210,110 @@ func Run(opts Options) error {
 			}
 		}
 	}
-	if !math.IsNaN(max) {
-		// Not all pixels are NaN.
-		spread := max - min
-		if spread > 0.0 {
-			// Normalize into range [0,1].
-			for i := range data {
-				if math.IsNaN(data[i]) {
-					data[i] = 0.0
-				} else {
-					data[i] = (data[i] - min) / spread
-				}
-			}
-		} else {
-			// Corner case: ~onstant field.
-			for i := range data {
-				data[i] = 0.5
-			}
-		}
-	} else {
-		// All NaNs.
-		for i := range data {
-			data[i] = 0.0
-		}
-	}
+	// Normalize the data, keeping the raw values.
+	data := append([]float64(nil), raw...)
+	r.norm.apply(data, lo, hi)
 
 	img := image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height))
 	for x := 0; x < opts.Width; x++ {
 		for y := 0; y < opts.Height; y++ {
 			pixel := data[y*opts.Width+x]
-			pos := int(pixel * float64(len(hm)-1))
-			r, g, b, a := hm[pos].RGBA()
-			img.SetRGBA64(x, y, color.RGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: uint16(a)})
+			var c color.Color
+			if math.IsNaN(pixel) {
+				c = r.nanColor
+			} else {
+				c = r.hm[int(pixel*float64(len(r.hm)-1))]
+			}
+			cr, cg, cb, ca := c.RGBA()
+			img.SetRGBA64(x, y, color.RGBA64{R: uint16(cr), G: uint16(cg), B: uint16(cb), A: uint16(ca)})
 		}
 	}
-	if opts.PostEdit != nil {
-		opts.PostEdit(img)
+	res := &Result{Image: img, Data: raw, Min: min, Max: max, Low: lo, High: hi, Mapping: r.m}
+	drawContours(opts, res)
+	if opts.Axes != nil {
+		drawAxes(gg.NewContextForRGBA(img), opts.Axes, r.m)
 	}
-	out, err := os.Create(opts.OutputFile)
-	if err != nil {
-		return fmt.Errorf("failed to create output file '%v': %v", opts.OutputFile, err)
+	return res
+}
+
+// drawColorBar draws the color bar of the range [lo,hi] over the image if one is set.
+func (r *renderer) drawColorBar(img draw.Image, lo, hi float64) error {
+	// There is nothing to show for a constant field, or one without any value in range.
+	if r.opts.ColorBar == nil || !(hi > lo) {
+		return nil
 	}
-	defer out.Close()
-	if err := png.Encode(out, img); err != nil {
-		return fmt.Errorf("failed to encode to PNG: %v", err)
+	cb := *r.opts.ColorBar
+	cb.Heatmap, cb.Min, cb.Max = r.hm, lo, hi
+	return colorbar.Draw(img, cb)
+}
+
+// sampler returns the function evaluating the field value of a pixel.
+func sampler(opts Options, m *Mapping) (func(x, y int) float64, error) {
+	if opts.WorldField != nil {
+		opts.SubpixelField = func(px, py float64) float64 {
+			return opts.WorldField(m.World(px, py))
+		}
+	}
+	if opts.SubpixelField == nil {
+		if opts.Field == nil {
+			return nil, fmt.Errorf("missing field function")
+		}
+		if opts.Samples > 1 {
+			return nil, fmt.Errorf("supersampling requires SubpixelField")
+		}
+		return opts.Field, nil
+	}
+	n := opts.Samples
+	if n < 1 {
+		n = 1
+	}
+	return func(x, y int) float64 {
+		sum, cnt := 0.0, 0
+		for j := 0; j < n; j++ {
+			for i := 0; i < n; i++ {
+				v := opts.SubpixelField(float64(x)+(float64(i)+0.5)/float64(n), float64(y)+(float64(j)+0.5)/float64(n))
+				if !math.IsNaN(v) {
+					sum += v
+					cnt++
+				}
+			}
+		}
+		if cnt == 0 {
+			return math.NaN()
+		}
+		return sum / float64(cnt)
+	}, nil
+}
+
+// drawContours extracts the contour lines from the raw data and strokes them on the image.
+func drawContours(opts Options, res *Result) {
+	levels := opts.ContourLevels
+	if len(levels) == 0 && opts.Contours > 0 && !math.IsNaN(res.Min) {
+		levels = contour.Levels(res.Min, res.Max, opts.Contours)
+	}
+	if len(levels) == 0 {
+		return
+	}
+	dc := gg.NewContextForRGBA(res.Image)
+	if opts.ContourColor != nil {
+		dc.SetColor(opts.ContourColor)
+	} else {
+		dc.SetColor(color.Black)
+	}
+	if opts.ContourWidth > 0.0 {
+		dc.SetLineWidth(opts.ContourWidth)
+	}
+	for _, level := range levels {
+		c := Contour{Level: level, Lines: contour.Lines(res.Data, opts.Width, opts.Height, level)}
+		for _, line := range c.Lines {
+			for k := range line {
+				// Samples are taken at pixel (x,y), which covers [x,x+1)x[y,y+1) on the image.
+				line[k][0] += 0.5
+				line[k][1] += 0.5
+				dc.LineTo(line[k][0], line[k][1])
+			}
+			dc.Stroke()
+		}
+		res.Contours = append(res.Contours, c)
 	}
-	return nil
 }
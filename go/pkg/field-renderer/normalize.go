@@ -0,0 +1,121 @@
+package fieldrenderer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Scale is how field values are mapped onto the heatmap.
+type Scale int
+
+const (
+	// ScaleLinear maps the range linearly.
+	ScaleLinear Scale = iota
+	// ScaleSymmetric widens the range to be symmetric around zero, so that zero sits in the middle of a diverging
+	// heatmap.
+	ScaleSymmetric
+	// ScaleDecibels maps 10*log10 of the values linearly, e.g., for intensities. Non-positive values map to the low
+	// end.
+	ScaleDecibels
+)
+
+// Normalization represents options to map field values onto the heatmap.
+type Normalization struct {
+	Scale Scale
+	// Fixed range of field values used if Max > Min, values outside are clamped. Otherwise the range of the data.
+	Min float64
+	Max float64
+	// Fraction of the data clipped at each end of the range, e.g., 0.01 for the 1st to 99th percentiles, so that a
+	// few singular pixels do not wash out the image. Ignored with a fixed range.
+	Clip float64
+	// Dynamic range in decibels below the top of the range with ScaleDecibels, defaults to the full range.
+	Decibels float64
+}
+
+// Validate checks the normalization options.
+func (n *Normalization) Validate() error {
+	if n.Scale < ScaleLinear || n.Scale > ScaleDecibels {
+		return fmt.Errorf("invalid scale %v", n.Scale)
+	}
+	if n.Clip < 0.0 || n.Clip >= 0.5 {
+		return fmt.Errorf("invalid clip fraction %v", n.Clip)
+	}
+	if n.Decibels < 0.0 {
+		return fmt.Errorf("invalid dynamic range %vdB", n.Decibels)
+	}
+	if n.Scale == ScaleDecibels && n.Max > n.Min && n.Min <= 0.0 {
+		return fmt.Errorf("decibel scale needs a positive range, got [%v,%v]", n.Min, n.Max)
+	}
+	return nil
+}
+
+// transform returns the value on the scale.
+func (n *Normalization) transform(v float64) float64 {
+	if n.Scale != ScaleDecibels {
+		return v
+	}
+	if v <= 0.0 {
+		return math.Inf(-1)
+	}
+	return 10.0 * math.Log10(v)
+}
+
+// Normalize maps the field values into [0,1] in place, leaving NaNs, and returns the range [lo,hi] of scaled values
+// mapped onto [0,1]. The range is NaN if there is no finite value to derive it from.
+func (n *Normalization) Normalize(data []float64) (float64, float64) {
+	lo, hi := n.adjust(n.dataRange(data))
+	n.apply(data, lo, hi)
+	return lo, hi
+}
+
+// dataRange returns the fixed range or the range of the data, clipped, as scaled values before they are adjusted to
+// the scale. NaN if there is no finite value.
+func (n *Normalization) dataRange(data []float64) (float64, float64) {
+	if n.Max > n.Min {
+		return n.transform(n.Min), n.transform(n.Max)
+	}
+	var finite []float64
+	for _, v := range data {
+		if v = n.transform(v); !math.IsNaN(v) && !math.IsInf(v, 0) {
+			finite = append(finite, v)
+		}
+	}
+	if len(finite) == 0 {
+		return math.NaN(), math.NaN()
+	}
+	sort.Float64s(finite)
+	k := int(n.Clip * float64(len(finite)-1))
+	return finite[k], finite[len(finite)-1-k]
+}
+
+// adjust returns the range of scaled values mapped onto [0,1] for the scale.
+func (n *Normalization) adjust(lo, hi float64) (float64, float64) {
+	if n.Scale == ScaleDecibels && n.Decibels > 0.0 {
+		lo = hi - n.Decibels
+	}
+	if n.Scale == ScaleSymmetric {
+		m := math.Max(math.Abs(lo), math.Abs(hi))
+		lo, hi = -m, m
+	}
+	return lo, hi
+}
+
+// apply maps the field values into [0,1] in place, given the range [lo,hi] of scaled values, leaving NaNs.
+func (n *Normalization) apply(data []float64, lo, hi float64) {
+	for i, v := range data {
+		v = n.transform(v)
+		switch {
+		case math.IsNaN(v):
+			data[i] = v
+		case math.IsNaN(lo):
+			// No finite value, only infinities are left.
+			data[i] = 0.5
+		case !(hi > lo):
+			// Corner case: constant field.
+			data[i] = 0.5
+		default:
+			data[i] = math.Min(math.Max((v-lo)/(hi-lo), 0.0), 1.0)
+		}
+	}
+}
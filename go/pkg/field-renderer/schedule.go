@@ -0,0 +1,144 @@
+package fieldrenderer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress is called with the number of rendered pixels out of the total.
+type Progress func(done, total int)
+
+// PrintProgress returns a Progress printing the percentage done to w, overwriting the line as it goes.
+func PrintProgress(w io.Writer) Progress {
+	erase := strings.Repeat(" ", 80)
+	return func(done, total int) {
+		if done == total {
+			fmt.Fprintf(w, "\r%v\rrendering complete\n", erase)
+			return
+		}
+		fmt.Fprintf(w, "\r%v\rrendering... %.2f%% done", erase, float64(done)/float64(total)*100.0)
+	}
+}
+
+// Defaults of the scheduler options.
+const (
+	defaultTileSize         = 32
+	defaultProgressInterval = 200 * time.Millisecond
+)
+
+// tile is the rectangle [x0,x1)x[y0,y1) of pixels.
+type tile struct {
+	x0, y0, x1, y1 int
+}
+
+// Schedule represents options to evaluate the pixels of an image in parallel, as the field renderer does.
+type Schedule struct {
+	// Number of goroutines evaluating the pixels, defaults to the number of CPUs.
+	Workers int
+	// Side of the square tiles of pixels the workers pull off the queue, defaults to 32.
+	TileSize int
+	// Called periodically with the rendering progress if set, and once when all pixels are done.
+	Progress Progress
+	// Period of the progress reports, defaults to 200ms.
+	ProgressInterval time.Duration
+}
+
+// renderTiles evaluates the pixels into data with the schedule of the options.
+func renderTiles(ctx context.Context, opts Options, sample func(x, y int) float64, data []float64) error {
+	s := Schedule{
+		Workers:          opts.Workers,
+		TileSize:         opts.TileSize,
+		Progress:         opts.Progress,
+		ProgressInterval: opts.ProgressInterval,
+	}
+	return s.Run(ctx, opts.Width, opts.Height, func(x, y int) { data[y*opts.Width+x] = sample(x, y) })
+}
+
+// Run calls pixel for every pixel of the width x height image, with workers pulling square tiles off a queue so that
+// each works on neighboring pixels. Progress is reported on a ticker and once all pixels are done. Returns early with
+// the context error if the context is done.
+func (s Schedule) Run(ctx context.Context, width, height int, pixel func(x, y int)) error {
+	size := s.TileSize
+	if size <= 0 {
+		size = defaultTileSize
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var tiles []tile
+	for y := 0; y < height; y += size {
+		for x := 0; x < width; x += size {
+			tiles = append(tiles, tile{x, y, min(x+size, width), min(y+size, height)})
+		}
+	}
+
+	next := int64(-1)
+	done := int64(0)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(tiles) {
+					return
+				}
+				t := tiles[i]
+				for y := t.y0; y < t.y1; y++ {
+					if ctx.Err() != nil {
+						return
+					}
+					for x := t.x0; x < t.x1; x++ {
+						pixel(x, y)
+					}
+					atomic.AddInt64(&done, int64(t.x1-t.x0))
+				}
+			}
+		}()
+	}
+
+	total := width * height
+	finished := make(chan struct{})
+	var reported sync.WaitGroup
+	if s.Progress != nil {
+		interval := s.ProgressInterval
+		if interval <= 0 {
+			interval = defaultProgressInterval
+		}
+		reported.Add(1)
+		go func() {
+			defer reported.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					// Completion is reported once, after the workers are done.
+					if d := int(atomic.LoadInt64(&done)); d < total {
+						s.Progress(d, total)
+					}
+				case <-finished:
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(finished)
+	reported.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.Progress != nil {
+		s.Progress(total, total)
+	}
+	return nil
+}
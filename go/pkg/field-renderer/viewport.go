@@ -0,0 +1,126 @@
+package fieldrenderer
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
+	"github.com/fogleman/gg"
+)
+
+// Aspect tells how a viewport is fitted onto an image whose aspect ratio differs from the viewport's.
+type Aspect int
+
+const (
+	// AspectFit keeps pixels square by widening the shorter range around its center, so the whole viewport is shown.
+	AspectFit Aspect = iota
+	// AspectFill keeps pixels square by narrowing the longer range around its center, so the image is covered.
+	AspectFill
+	// AspectStretch maps the viewport exactly onto the image, pixels may not be square.
+	AspectStretch
+)
+
+// Viewport is the world rectangle shown on the image, x to the right and y upwards.
+type Viewport struct {
+	XMin   float64
+	XMax   float64
+	YMin   float64
+	YMax   float64
+	Aspect Aspect
+}
+
+// Mapping maps between pixel coordinates of an image and world coordinates of the viewport shown on it.
+type Mapping struct {
+	// The world rectangle actually shown, after the aspect ratio is applied.
+	XMin float64
+	XMax float64
+	YMin float64
+	YMax float64
+	// Size of the image in pixels.
+	Width  int
+	Height int
+}
+
+// Map fits the viewport onto an image of the given size.
+func (v Viewport) Map(width, height int) (*Mapping, error) {
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("invalid image size %vx%v", width, height)
+	}
+	if !(v.XMax > v.XMin) || !(v.YMax > v.YMin) {
+		return nil, fmt.Errorf("invalid viewport [%v,%v]x[%v,%v]", v.XMin, v.XMax, v.YMin, v.YMax)
+	}
+	m := &Mapping{XMin: v.XMin, XMax: v.XMax, YMin: v.YMin, YMax: v.YMax, Width: width, Height: height}
+	if v.Aspect == AspectStretch {
+		return m, nil
+	}
+	// World units per pixel along each axis.
+	sx := (v.XMax - v.XMin) / float64(width)
+	sy := (v.YMax - v.YMin) / float64(height)
+	s := math.Max(sx, sy)
+	if v.Aspect == AspectFill {
+		s = math.Min(sx, sy)
+	}
+	cx, cy := (v.XMin+v.XMax)/2.0, (v.YMin+v.YMax)/2.0
+	m.XMin, m.XMax = cx-s*float64(width)/2.0, cx+s*float64(width)/2.0
+	m.YMin, m.YMax = cy-s*float64(height)/2.0, cy+s*float64(height)/2.0
+	return m, nil
+}
+
+// World returns the world coordinates at continuous pixel coordinates (px,py), where pixel (x,y) covers
+// [x,x+1)x[y,y+1).
+func (m *Mapping) World(px, py float64) (float64, float64) {
+	return m.XMin + px/float64(m.Width)*(m.XMax-m.XMin), m.YMax - py/float64(m.Height)*(m.YMax-m.YMin)
+}
+
+// Pixel returns the continuous pixel coordinates of the world point (x,y).
+func (m *Mapping) Pixel(x, y float64) (float64, float64) {
+	return (x - m.XMin) / (m.XMax - m.XMin) * float64(m.Width), (m.YMax - y) / (m.YMax - m.YMin) * float64(m.Height)
+}
+
+// Axes represents options to draw tick marks and labels along the bottom and left edges of the image.
+type Axes struct {
+	// Approximate number of ticks along each axis, default to 5.
+	XTicks int
+	YTicks int
+	// Axis titles, drawn at the ends of the axes if not empty.
+	XLabel string
+	YLabel string
+	// Color of the ticks and labels, defaults to white.
+	Color color.Color
+}
+
+// Length of the tick marks in pixels.
+const tickLength = 6.0
+
+// drawAxes draws the ticks and labels of the mapping's world coordinates on the image.
+func drawAxes(dc *gg.Context, axes *Axes, m *Mapping) {
+	if axes.Color != nil {
+		dc.SetColor(axes.Color)
+	} else {
+		dc.SetColor(color.White)
+	}
+	dc.SetLineWidth(1)
+	w, h := float64(m.Width), float64(m.Height)
+	// Tick labels sit inside the image, next to the tick marks.
+	xt, xstep := colorbar.Ticks(m.XMin, m.XMax, axes.XTicks)
+	for _, x := range xt {
+		px, _ := m.Pixel(x, 0)
+		dc.DrawLine(px, h, px, h-tickLength)
+		dc.Stroke()
+		dc.DrawStringAnchored(colorbar.TickLabel(x, xstep), px, h-tickLength-2, 0.5, 0)
+	}
+	yt, ystep := colorbar.Ticks(m.YMin, m.YMax, axes.YTicks)
+	for _, y := range yt {
+		_, py := m.Pixel(0, y)
+		dc.DrawLine(0, py, tickLength, py)
+		dc.Stroke()
+		dc.DrawStringAnchored(colorbar.TickLabel(y, ystep), tickLength+2, py, 0, 0.5)
+	}
+	if axes.XLabel != "" {
+		dc.DrawStringAnchored(axes.XLabel, w-4, h-tickLength-dc.FontHeight()-6, 1, 0)
+	}
+	if axes.YLabel != "" {
+		dc.DrawStringAnchored(axes.YLabel, tickLength+2, 6, 0, 1)
+	}
+}
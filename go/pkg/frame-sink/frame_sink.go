@@ -0,0 +1,186 @@
+package framesink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+
+	"github.com/euphoricrhino/jackson-em-notes/go/pkg/animation"
+)
+
+// Frame is a rendered frame handed to a FrameSink.
+type Frame struct {
+	// Frame number, counting from 0 at the start of each run.
+	Index int
+	// Rendered image, nil for renderers producing field values only.
+	Image image.Image
+	// Field values behind the image in row-major order, nil for renderers not producing them.
+	Data []float64
+}
+
+// FrameSink receives the frames of a single renderer run in order. Renderers write to the sink but leave closing it
+// to the caller, which knows whether the run succeeded.
+type FrameSink interface {
+	Write(f Frame) error
+	// Close flushes the frames written so far, e.g., finishes the animation file.
+	Close() error
+	// Abort is Close for a failed run, discarding output that would be left unusable, e.g., a truncated animation
+	// file. Frames saved to files of their own are kept.
+	Abort() error
+}
+
+// SavePNG encodes the image into the PNG file.
+func SavePNG(filename string, img image.Image) error {
+	out, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create output file '%v': %v", filename, err)
+	}
+	defer out.Close()
+	if err := png.Encode(out, img); err != nil {
+		return fmt.Errorf("failed to encode to PNG: %v", err)
+	}
+	return out.Close()
+}
+
+type pngSink struct {
+	pattern string
+}
+
+// NewPNG returns a sink saving each frame image into a PNG file named by formatting the pattern with the frame
+// number, e.g., "/tmp/frames/frame-%04d.png".
+func NewPNG(pattern string) FrameSink {
+	return &pngSink{pattern: pattern}
+}
+
+func (s *pngSink) Write(f Frame) error {
+	if f.Image == nil {
+		return fmt.Errorf("frame %v has no image", f.Index)
+	}
+	return SavePNG(fmt.Sprintf(s.pattern, f.Index), f.Image)
+}
+
+func (s *pngSink) Close() error {
+	return nil
+}
+
+func (s *pngSink) Abort() error {
+	return nil
+}
+
+type rawSink struct {
+	pattern string
+}
+
+// NewRaw returns a sink dumping the field values of each frame as little endian float64s into a file named by
+// formatting the pattern with the frame number, e.g., "/tmp/mie-total-%03d.data".
+func NewRaw(pattern string) FrameSink {
+	return &rawSink{pattern: pattern}
+}
+
+func (s *rawSink) Write(f Frame) error {
+	if f.Data == nil {
+		return fmt.Errorf("frame %v has no field values", f.Index)
+	}
+	filename := fmt.Sprintf(s.pattern, f.Index)
+	out, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create output file '%v': %v", filename, err)
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	if err := binary.Write(w, binary.LittleEndian, f.Data); err != nil {
+		return fmt.Errorf("failed to write '%v': %v", filename, err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write '%v': %v", filename, err)
+	}
+	return out.Close()
+}
+
+func (s *rawSink) Close() error {
+	return nil
+}
+
+func (s *rawSink) Abort() error {
+	return nil
+}
+
+type animationSink struct {
+	enc *animation.Encoder
+}
+
+// NewAnimation returns a sink encoding the frame images into an animated GIF or APNG file. Frames must be written in
+// order.
+func NewAnimation(opts animation.Options) (FrameSink, error) {
+	enc, err := animation.NewEncoder(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &animationSink{enc: enc}, nil
+}
+
+// NewAnimationFile is NewAnimation at the frame rate with the format told by the file extension, .gif or .png/.apng,
+// and the GIF frames quantized to the palette, e.g., the heatmap the frames are colored with.
+func NewAnimationFile(filename string, frameRate float64, palette []color.Color) (FrameSink, error) {
+	format, err := animation.FormatOf(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewAnimation(animation.Options{OutputFile: filename, Format: format, FrameRate: frameRate, Palette: palette})
+}
+
+func (s *animationSink) Write(f Frame) error {
+	if f.Image == nil {
+		return fmt.Errorf("frame %v has no image", f.Index)
+	}
+	return s.enc.Add(f.Image)
+}
+
+func (s *animationSink) Close() error {
+	return s.enc.Close()
+}
+
+func (s *animationSink) Abort() error {
+	return s.enc.Abort()
+}
+
+type multiSink []FrameSink
+
+// Multi returns a sink writing each frame to all the sinks in order, e.g., images to an animation and field values
+// to raw dumps.
+func Multi(sinks ...FrameSink) FrameSink {
+	return multiSink(sinks)
+}
+
+func (ms multiSink) Write(f Frame) error {
+	for _, s := range ms {
+		if err := s.Write(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms multiSink) Close() error {
+	var first error
+	for _, s := range ms {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (ms multiSink) Abort() error {
+	var first error
+	for _, s := range ms {
+		if err := s.Abort(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
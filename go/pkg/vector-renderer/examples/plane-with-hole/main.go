@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"os"
+
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
+	fieldrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-renderer"
+	vectorrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/vector-renderer"
+)
+
+var (
+	heatmap = flag.String("heatmap", "", "heatmap file")
+	output  = flag.String("output", "", "output file")
+	gamma   = flag.Float64("gamma", 1.0, "gamma correction")
+	width   = flag.Int("width", 800, "output width")
+	height  = flag.Int("height", 800, "output height")
+	mode    = flag.String("mode", "lic", "quiver|lic")
+	clip    = flag.Float64("clip", 0.01, "fraction of the weakest and strongest field clipped, the field diverges at the rim")
+	legend  = flag.Bool("legend", false, "draw a color bar of the field magnitude")
+)
+
+// Conducting plane at z=0 with a circular hole of radius a, section 3.13. Coordinates are (rho,z) in the x-y plane.
+const (
+	a  = 0.35
+	e0 = 1.0
+	e1 = 0.2
+)
+
+func potential(rho, z float64) float64 {
+	l := (z*z + rho*rho - a*a) / (a * a)
+	r := math.Sqrt(l*l + 4*z*z/(a*a))
+	v1 := math.Sqrt((r - l) / 2)
+	v2 := math.Abs(z) / a * math.Atan(math.Sqrt(2/(r+l)))
+	ret := (e0 - e1) * a / math.Pi * (v1 - v2)
+	if z > 0 {
+		ret += e0 * z
+	} else {
+		ret += e1 * z
+	}
+	return ret
+}
+
+func main() {
+	flag.Parse()
+
+	// E=-grad(potential) by central differences.
+	field := func(rho, z float64) (float64, float64) {
+		const d = 1e-6
+		return -(potential(rho+d, z) - potential(rho-d, z)) / (2 * d),
+			-(potential(rho, z+d) - potential(rho, z-d)) / (2 * d)
+	}
+
+	opts := vectorrenderer.Options{
+		HeatMapFile:   *heatmap,
+		OutputFile:    *output,
+		Gamma:         *gamma,
+		Width:         *width,
+		Height:        *height,
+		Viewport:      fieldrenderer.Viewport{XMin: -1, XMax: 1, YMin: -1, YMax: 1},
+		Field:         field,
+		Progress:      fieldrenderer.PrintProgress(os.Stdout),
+		Normalization: &fieldrenderer.Normalization{Clip: *clip},
+	}
+	if *mode == "quiver" {
+		opts.Mode = vectorrenderer.Quiver
+	} else {
+		opts.Mode = vectorrenderer.LIC
+	}
+	if *legend {
+		opts.ColorBar = &colorbar.Options{Label: "|E|"}
+	}
+	if err := vectorrenderer.Run(opts); err != nil {
+		panic(err)
+	}
+}
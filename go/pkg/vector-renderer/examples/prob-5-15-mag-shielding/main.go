@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"os"
+
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
+	fieldrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-renderer"
+	vectorrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/vector-renderer"
+)
+
+var (
+	heatmap = flag.String("heatmap", "", "heatmap file")
+	output  = flag.String("output", "", "output file")
+	gamma   = flag.Float64("gamma", 1.0, "gamma correction")
+	width   = flag.Int("width", 800, "output width")
+	height  = flag.Int("height", 800, "output height")
+	mode    = flag.String("mode", "lic", "quiver|lic")
+	db      = flag.Float64("db", 60, "dynamic range of the field magnitude in decibels")
+	legend  = flag.Bool("legend", false, "draw a color bar of the field magnitude")
+)
+
+func main() {
+	flag.Parse()
+
+	b := 0.6
+	a := b * 0.9
+	mu := 100.0
+	den := (mu+1)*(mu+1)*b*b - (mu-1)*(mu-1)*a*a
+	inFactor := (mu*mu - 1) * (b*b - a*a) / den / (a * a)
+	ringFactor1 := 2 * (mu - 1) / den
+	ringFactor2 := 2 * (mu + 1) * b * b / den
+	outFactor := 4 * mu * b * b / den
+
+	// Same field as the field line example of problem 5-15, without its scale for tracing.
+	field := func(x, y float64) (float64, float64) {
+		rho := math.Hypot(x, y)
+		cp, sp := x/rho, y/rho
+		rho2 := rho * rho
+		switch {
+		case rho <= a:
+			return -2 * cp * sp / rho2, (cp*cp-sp*sp)/rho2 - inFactor
+		case rho <= b:
+			return -2 * cp * sp / rho2 * ringFactor2, (cp*cp-sp*sp)/rho2*ringFactor2 + ringFactor1
+		default:
+			return -2 * cp * sp / rho2 * outFactor, (cp*cp - sp*sp) / rho2 * outFactor
+		}
+	}
+
+	opts := vectorrenderer.Options{
+		HeatMapFile: *heatmap,
+		OutputFile:  *output,
+		Gamma:       *gamma,
+		Width:       *width,
+		Height:      *height,
+		Viewport:    fieldrenderer.Viewport{XMin: -1, XMax: 1, YMin: -1, YMax: 1},
+		Field:       field,
+		Progress:    fieldrenderer.PrintProgress(os.Stdout),
+		// 10*log10 of the magnitude, the field inside is orders of magnitude weaker than near the dipole.
+		Normalization: &fieldrenderer.Normalization{Scale: fieldrenderer.ScaleDecibels, Decibels: *db},
+	}
+	if *mode == "quiver" {
+		opts.Mode = vectorrenderer.Quiver
+	} else {
+		opts.Mode = vectorrenderer.LIC
+	}
+	if *legend {
+		opts.ColorBar = &colorbar.Options{Label: "dB"}
+	}
+	if err := vectorrenderer.Run(opts); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,342 @@
+package vectorrenderer
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"time"
+
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
+	fieldrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-renderer"
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
+	"github.com/euphoricrhino/jackson-em-notes/go/pkg/heatmap"
+	"github.com/fogleman/gg"
+)
+
+// Mode is how the vector field is drawn.
+type Mode int
+
+const (
+	// Quiver draws a grid of arrows along the field, colored by its magnitude.
+	Quiver Mode = iota
+	// LIC draws a line integral convolution texture, i.e., noise smeared along the field lines, colored by the
+	// magnitude of the field.
+	LIC
+)
+
+// Options represents options to run the vector renderer.
+type Options struct {
+	HeatMapFile string
+	OutputFile  string
+	// If set, the image is written to the sink as frame 0 instead of being saved to OutputFile.
+	Sink framesink.FrameSink
+	// Gamma correction to be applied to heatmap.
+	Gamma  float64
+	Width  int
+	Height int
+	// World rectangle shown on the image.
+	Viewport fieldrenderer.Viewport
+	// Field function at world coordinates (x,y). Return math.NaN to indicate divergence.
+	Field func(x, y float64) (float64, float64)
+	Mode  Mode
+	// How the field magnitude is mapped onto the heatmap, defaults to linearly from the minimum to the maximum.
+	Normalization *fieldrenderer.Normalization
+	// Color bar legend of the field magnitude drawn over the image if set, see fieldrenderer.Options.
+	ColorBar *colorbar.Options
+
+	// Distance between arrows in pixels for Quiver, defaults to 24. Arrows have the same length, 80% of Spacing.
+	Spacing int
+	// Width of the arrows, defaults to 1.5.
+	LineWidth float64
+	// Background of the quiver plot, defaults to black.
+	Background color.Color
+
+	// Length in pixels of the streamline traced each way from a pixel for LIC, defaults to 20.
+	KernelLength int
+	// Seed of the noise texture for LIC, so that frames of an animation share the same noise.
+	Seed int64
+
+	// Scheduling of the pixels as with fieldrenderer.Options. Progress counts the LIC convolution as a second pass
+	// over the pixels.
+	Workers          int
+	TileSize         int
+	Progress         fieldrenderer.Progress
+	ProgressInterval time.Duration
+}
+
+// Run runs the vector renderer with the given options and saves the image.
+func Run(opts Options) error {
+	return RunContext(context.Background(), opts)
+}
+
+// RunContext is Run stopping early with the context error if the context is done.
+func RunContext(ctx context.Context, opts Options) error {
+	img, err := RenderContext(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Sink != nil {
+		return opts.Sink.Write(framesink.Frame{Image: img})
+	}
+	return framesink.SavePNG(opts.OutputFile, img)
+}
+
+// Render renders the vector field into an image without saving it. OutputFile and Sink are ignored.
+func Render(opts Options) (*image.RGBA, error) {
+	return RenderContext(context.Background(), opts)
+}
+
+// RenderContext is Render stopping early with the context error if the context is done.
+func RenderContext(ctx context.Context, opts Options) (*image.RGBA, error) {
+	if opts.Field == nil {
+		return nil, fmt.Errorf("missing field function")
+	}
+	if opts.Mode != Quiver && opts.Mode != LIC {
+		return nil, fmt.Errorf("invalid mode %v", opts.Mode)
+	}
+	if opts.Spacing < 0 || opts.KernelLength < 0 {
+		return nil, fmt.Errorf("invalid spacing %v or kernel length %v", opts.Spacing, opts.KernelLength)
+	}
+	m, err := opts.Viewport.Map(opts.Width, opts.Height)
+	if err != nil {
+		return nil, err
+	}
+	norm := opts.Normalization
+	if norm == nil {
+		norm = &fieldrenderer.Normalization{}
+	}
+	if err := norm.Validate(); err != nil {
+		return nil, err
+	}
+	hm, err := heatmap.Load(opts.HeatMapFile, opts.Gamma)
+	if err != nil {
+		return nil, err
+	}
+
+	passes := 1
+	if opts.Mode == LIC {
+		passes = 2
+	}
+	f, err := sample(ctx, opts.schedule(0, passes), opts, m)
+	if err != nil {
+		return nil, err
+	}
+	mag := append([]float64(nil), f.mag...)
+	lo, hi := norm.Normalize(mag)
+	// colorAt returns the heatmap color of the normalized magnitude at pixel index i, nil where divergent.
+	colorAt := func(i int) color.Color {
+		if math.IsNaN(mag[i]) {
+			return nil
+		}
+		return hm[int(mag[i]*float64(len(hm)-1))]
+	}
+
+	var img *image.RGBA
+	if opts.Mode == Quiver {
+		img = quiver(opts, f, colorAt)
+	} else if img, err = lic(ctx, opts.schedule(1, passes), opts, f, colorAt); err != nil {
+		return nil, err
+	}
+	if opts.ColorBar != nil && hi > lo {
+		cb := *opts.ColorBar
+		cb.Heatmap, cb.Min, cb.Max = hm, lo, hi
+		if err := colorbar.Draw(img, cb); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// schedule returns the schedule of the given pass over the pixels, reporting the progress of all passes together.
+func (opts Options) schedule(pass, passes int) fieldrenderer.Schedule {
+	s := fieldrenderer.Schedule{Workers: opts.Workers, TileSize: opts.TileSize, ProgressInterval: opts.ProgressInterval}
+	if opts.Progress != nil {
+		s.Progress = func(done, total int) {
+			// Completion is reported once, at the end of the last pass.
+			if done == total && pass < passes-1 {
+				return
+			}
+			opts.Progress(pass*total+done, passes*total)
+		}
+	}
+	return s
+}
+
+// sampled is the field sampled at pixel centers, row-major.
+type sampled struct {
+	w, h int
+	// Unit direction on the screen, zero where the field vanishes or diverges.
+	ux, uy []float64
+	// Magnitude of the field, NaN where divergent.
+	mag []float64
+}
+
+// sample evaluates the field at the pixel centers in parallel.
+func sample(
+	ctx context.Context, sched fieldrenderer.Schedule, opts Options, m *fieldrenderer.Mapping,
+) (*sampled, error) {
+	w, h := opts.Width, opts.Height
+	f := &sampled{
+		w:   w,
+		h:   h,
+		ux:  make([]float64, w*h),
+		uy:  make([]float64, w*h),
+		mag: make([]float64, w*h),
+	}
+	// Pixels per world unit along each axis, which differ with fieldrenderer.AspectStretch.
+	sx, sy := float64(w)/(m.XMax-m.XMin), float64(h)/(m.YMax-m.YMin)
+	err := sched.Run(ctx, w, h, func(x, y int) {
+		i := y*w + x
+		vx, vy := opts.Field(m.World(float64(x)+0.5, float64(y)+0.5))
+		f.mag[i] = math.Hypot(vx, vy)
+		if math.IsNaN(f.mag[i]) {
+			return
+		}
+		// The screen y axis points down.
+		dx, dy := vx*sx, -vy*sy
+		if d := math.Hypot(dx, dy); d > 0.0 && !math.IsInf(d, 0) {
+			f.ux[i], f.uy[i] = dx/d, dy/d
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// quiver draws an arrow at the center of each grid cell.
+func quiver(opts Options, f *sampled, colorAt func(i int) color.Color) *image.RGBA {
+	spacing := opts.Spacing
+	if spacing == 0 {
+		spacing = 24
+	}
+	lineWidth := opts.LineWidth
+	if lineWidth == 0.0 {
+		lineWidth = 1.5
+	}
+	img := image.NewRGBA(image.Rect(0, 0, f.w, f.h))
+	dc := gg.NewContextForRGBA(img)
+	if opts.Background != nil {
+		dc.SetColor(opts.Background)
+	} else {
+		dc.SetColor(color.Black)
+	}
+	dc.Clear()
+	dc.SetLineWidth(lineWidth)
+	half := 0.4 * float64(spacing)
+	head := 0.35 * float64(spacing)
+	for y := spacing / 2; y < f.h; y += spacing {
+		for x := spacing / 2; x < f.w; x += spacing {
+			i := y*f.w + x
+			c := colorAt(i)
+			if c == nil || f.ux[i] == 0.0 && f.uy[i] == 0.0 {
+				continue
+			}
+			ux, uy := f.ux[i], f.uy[i]
+			cx, cy := float64(x)+0.5, float64(y)+0.5
+			tx, ty := cx+ux*half, cy+uy*half
+			dc.SetColor(c)
+			dc.DrawLine(cx-ux*half, cy-uy*half, tx, ty)
+			dc.Stroke()
+			// Arrowhead with a half angle of 20 degrees.
+			const s, co = 0.3420201433256687, 0.9396926207859084
+			dc.MoveTo(tx, ty)
+			dc.LineTo(tx-head*(ux*co-uy*s), ty-head*(uy*co+ux*s))
+			dc.LineTo(tx-head*(ux*co+uy*s), ty-head*(uy*co-ux*s))
+			dc.ClosePath()
+			dc.Fill()
+		}
+	}
+	return img
+}
+
+// lic convolves white noise along the streamlines through each pixel with a box kernel, and modulates the heatmap
+// color with the result.
+func lic(
+	ctx context.Context, sched fieldrenderer.Schedule, opts Options, f *sampled, colorAt func(i int) color.Color,
+) (*image.RGBA, error) {
+	length := opts.KernelLength
+	if length == 0 {
+		length = 20
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	noise := make([]float64, f.w*f.h)
+	for i := range noise {
+		noise[i] = rng.Float64()
+	}
+	// dir returns the field direction at the continuous pixel coordinates, and false outside the image.
+	dir := func(px, py float64) (float64, float64, bool) {
+		x, y := int(math.Floor(px)), int(math.Floor(py))
+		if x < 0 || x >= f.w || y < 0 || y >= f.h {
+			return 0, 0, false
+		}
+		return f.ux[y*f.w+x], f.uy[y*f.w+x], true
+	}
+
+	tex := make([]float64, f.w*f.h)
+	err := sched.Run(ctx, f.w, f.h, func(x, y int) {
+		sum, cnt := noise[y*f.w+x], 1
+		for _, sgn := range []float64{1, -1} {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			// Midpoint steps of one pixel.
+			for s := 0; s < length; s++ {
+				dx, dy, ok := dir(px, py)
+				if !ok || dx == 0.0 && dy == 0.0 {
+					break
+				}
+				mx, my, ok := dir(px+sgn*dx/2, py+sgn*dy/2)
+				if !ok || mx*dx+my*dy <= 0.0 {
+					// Stop at sinks, sources and where the field flips.
+					break
+				}
+				px, py = px+sgn*mx, py+sgn*my
+				ix, iy := int(math.Floor(px)), int(math.Floor(py))
+				if ix < 0 || ix >= f.w || iy < 0 || iy >= f.h {
+					break
+				}
+				sum += noise[iy*f.w+ix]
+				cnt++
+			}
+		}
+		tex[y*f.w+x] = sum / float64(cnt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Averaging flattens the noise, stretch the texture to 2.5 standard deviations around its mean.
+	mean, sq := 0.0, 0.0
+	for _, v := range tex {
+		mean += v
+		sq += v * v
+	}
+	mean /= float64(len(tex))
+	std := math.Sqrt(math.Max(sq/float64(len(tex))-mean*mean, 0.0))
+	if std == 0.0 {
+		std = 1.0
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, f.w, f.h))
+	for y := 0; y < f.h; y++ {
+		for x := 0; x < f.w; x++ {
+			i := y*f.w + x
+			c := colorAt(i)
+			if c == nil {
+				img.SetRGBA(x, y, color.RGBA{A: 0xff})
+				continue
+			}
+			t := math.Min(math.Max(0.5+(tex[i]-mean)/(5.0*std), 0.0), 1.0)
+			r, g, b, _ := c.RGBA()
+			img.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(float64(r) * t),
+				G: uint16(float64(g) * t),
+				B: uint16(float64(b) * t),
+				A: 0xffff,
+			})
+		}
+	}
+	return img, nil
+}
@@ -3,25 +3,30 @@ package main
 import (
 	"flag"
 	"math"
+	"os"
 
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
 	fieldrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-renderer"
 )
 
 var (
-	heatmap = flag.String("heatmap", "", "heatmap file")
-	output  = flag.String("output", "", "output file")
-	gamma   = flag.Float64("gamma", 1.0, "gamma correction")
-	width   = flag.Int("width", 640, "output width")
-	height  = flag.Int("height", 640, "output height")
+	heatmap  = flag.String("heatmap", "", "heatmap file")
+	output   = flag.String("output", "", "output file")
+	gamma    = flag.Float64("gamma", 1.0, "gamma correction")
+	width    = flag.Int("width", 640, "output width")
+	height   = flag.Int("height", 640, "output height")
+	contours = flag.Int("contours", 0, "number of equipotential contours to draw")
+	samples  = flag.Int("samples", 1, "supersample each pixel with samples x samples field evaluations")
+	legend   = flag.Bool("legend", false, "draw a color bar of the potential")
+	axes     = flag.Bool("axes", false, "draw axis ticks in units of the hole radius")
 )
 
 func main() {
 	flag.Parse()
 
-	field := func(x, y int) float64 {
-		fx := float64(x) - float64(*width-1)/2
-		fy := float64(*height-1-y) - float64(*height-1)/2
-		a := float64(*width) / 8
+	// Field at (fx,fy) in units of the hole radius.
+	field := func(fx, fy float64) float64 {
+		const a = 1.0
 		a2 := a * a
 		l := (fy*fy + fx*fx - a2) / a2
 		r := math.Sqrt(l*l + 4.0*fy*fy/a2)
@@ -38,14 +43,25 @@ func main() {
 		return ret
 	}
 
-	if err := fieldrenderer.Run(fieldrenderer.Options{
+	opts := fieldrenderer.Options{
 		HeatMapFile: *heatmap,
 		OutputFile:  *output,
 		Gamma:       *gamma,
 		Width:       *width,
 		Height:      *height,
-		Field:       field,
-	}); err != nil {
+		Progress:    fieldrenderer.PrintProgress(os.Stdout),
+		WorldField:  field,
+		Viewport:    &fieldrenderer.Viewport{XMin: -4, XMax: 4, YMin: -4, YMax: 4},
+		Samples:     *samples,
+		Contours:    *contours,
+	}
+	if *axes {
+		opts.Axes = &fieldrenderer.Axes{XLabel: "rho/a", YLabel: "z/a"}
+	}
+	if *legend {
+		opts.ColorBar = &colorbar.Options{Label: "potential"}
+	}
+	if err := fieldrenderer.Run(opts); err != nil {
 		panic(err)
 	}
 }
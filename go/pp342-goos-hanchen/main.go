@@ -7,6 +7,7 @@ import (
 	"image/draw"
 	"math"
 	"math/cmplx"
+	"os"
 
 	fieldrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-renderer"
 	"github.com/llgcode/draw2d"
@@ -40,6 +41,8 @@ var (
 	refrIdx        = flag.Float64("refr-idx", 0.0, "relative refraction index n'/n")
 	perpPol        = flag.Bool("perp-pol", true, "whether to consider perpendicular or parallel polarization")
 	widthInLambdas = flag.Float64("width-in-lambdas", 0, "how many lambdas does the image's width represent")
+	window         = flag.Int("window", 0, "if positive, normalize each frame with the frames this many steps around it instead of all frames")
+	anim           = flag.String("animation", "", "if set, encode the frames into this .gif or .png (APNG) file instead of numbered pngs")
 )
 
 func main() {
@@ -52,19 +55,31 @@ func main() {
 	deltaAng := math.Pi / 2.0 / frames
 
 	imgWidth, imgHeight := float64(*width), float64(*height)
-	centerPixelX, centerPixelY := imgWidth/2.0, imgHeight/3.0
+	// The interface z=0 is a third of the way down the image, x=0 in the middle, in units of lambda.
+	heightInLambdas := *widthInLambdas * imgHeight / imgWidth
+	viewport := &fieldrenderer.Viewport{
+		XMin:   -*widthInLambdas / 2.0,
+		XMax:   *widthInLambdas / 2.0,
+		YMin:   -heightInLambdas * 2.0 / 3.0,
+		YMax:   heightInLambdas / 3.0,
+		Aspect: fieldrenderer.AspectStretch,
+	}
+	m, err := viewport.Map(*width, *height)
+	if err != nil {
+		panic(err)
+	}
+	centerPixelX, centerPixelY := m.Pixel(0, 0)
+	// The field and annotations of each frame, rendered together so that the frames share the same normalization.
+	fields := make([]func(float64, float64) float64, frames+1)
+	postEdits := make([]func(draw.Image), frames+1)
 	for f := 0; f <= frames; f++ {
 		incAng := float64(f) * deltaAng
 		// Decompose incident beam into many plane waves, each with slightly different wave vector and amplitude, by Fourier transform.
 		incWp := constructIncidentWaveParams(kappaLimit, dkappa, *beta, incAng)
 		reflWp, transWp := reflectAndTransmit(incWp, *perpPol, complex(*refrIdx, 0))
 
-		pixelsPerWavelength := imgWidth / *widthInLambdas
-
-		field := func(screenX, screenY int) float64 {
-			pixelX := float64(screenX) - centerPixelX
-			pixelY := centerPixelY - float64(screenY)
-			x, z := complex(pixelX/pixelsPerWavelength, 0), complex(pixelY/pixelsPerWavelength, 0)
+		field := func(fx, fz float64) float64 {
+			x, z := complex(fx, 0), complex(fz, 0)
 
 			amp := complex(0, 0)
 			if real(z) > 0.0 {
@@ -149,7 +164,8 @@ func main() {
 					text = fmt.Sprintf("measured D=%.02fλ", x0*cosi)
 					gc.FillStringAt(text, 20.0, 80.0)
 					// Draw the measured optical center line for reflected field.
-					drawRay(gc, imgWidth, imgHeight, centerPixelX+x0*pixelsPerWavelength, centerPixelY, math.Pi/2-incAng, color.RGBA{0xff, 0, 0, 0xff})
+					shiftedX, _ := m.Pixel(x0, 0)
+					drawRay(gc, imgWidth, imgHeight, shiftedX, centerPixelY, math.Pi/2-incAng, color.RGBA{0xff, 0, 0, 0xff})
 				}
 			}
 
@@ -160,18 +176,30 @@ func main() {
 			gc.Stroke()
 		}
 
-		if err := fieldrenderer.Run(fieldrenderer.Options{
+		fields[f], postEdits[f] = field, postEdit
+	}
+
+	if err := fieldrenderer.RunAnimation(fieldrenderer.Animation{
+		Options: fieldrenderer.Options{
 			HeatMapFile: *heatmap,
-			OutputFile:  fmt.Sprintf("%s-%04d.png", *output, f),
 			Gamma:       *gamma,
 			Width:       *width,
 			Height:      *height,
-			Field:       field,
-			PostEdit:    postEdit,
-		}); err != nil {
-			panic(err)
-		}
-		fmt.Printf("frame %04d done\n", f)
+			Progress:    fieldrenderer.PrintProgress(os.Stdout),
+			Viewport:    viewport,
+		},
+		Frames:        frames + 1,
+		Field:         func(f int, x, z float64) float64 { return fields[f](x, z) },
+		OutputPattern: *output + "-%04d.png",
+		AnimationFile: *anim,
+		FrameRate:     20,
+		Window:        *window,
+		PostEdit: func(f int, img draw.Image) {
+			postEdits[f](img)
+			fmt.Printf("frame %04d done\n", f)
+		},
+	}); err != nil {
+		panic(err)
 	}
 }
 
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"image/color"
 	"image/draw"
-	"image/png"
 	"math"
 	"os"
 	"path/filepath"
@@ -17,6 +16,9 @@ import (
 	"github.com/euphoricrhino/go-common/graphix/zraster"
 	"github.com/llgcode/draw2d"
 	"github.com/llgcode/draw2d/draw2dimg"
+
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
 )
 
 // Example commands:
@@ -37,6 +39,8 @@ var (
 	mode = flag.String("mode", "", "has to start with TM|TE")
 
 	outDir = flag.String("out-dir", "", "output dir")
+	legend = flag.Bool("legend", false, "draw color bars of the heatmaps")
+	anim   = flag.String("animation", "", "if set, encode the frames into this .gif or .png (APNG) file instead of pngs in the output dir")
 )
 
 const (
@@ -147,6 +151,23 @@ func main() {
 
 	frameCnt := orbitPeriods * framesPerPeriod
 
+	sink := framesink.NewPNG(filepath.Join(*outDir, "frame-%04d.png"))
+	if *anim != "" {
+		// Quantize GIF frames to the colors of both heatmaps.
+		pal := append(append([]color.Color{}, ehm...), hhm...)
+		var err error
+		if sink, err = framesink.NewAnimationFile(*anim, 20.5, pal); err != nil {
+			panic(err)
+		}
+	}
+	// Rendering panics on failure, so remove an unfinished animation on the way out.
+	finished := false
+	defer func() {
+		if !finished {
+			sink.Abort()
+		}
+	}()
+
 	// Configuration for the camera orbit.
 	n := graphix.NewVec3(math.Cos(cameraOrbitAxisAngle), math.Sin(cameraOrbitAxisAngle), 0)
 	pos := graphix.NewVec3(0, 0, -d)
@@ -194,17 +215,18 @@ func main() {
 			Workers: runtime.NumCPU(),
 		})
 		renderCaption(img, f, frameCnt)
-		fn := filepath.Join(*outDir, fmt.Sprintf("frame-%04v.png", f))
-		file, err := os.Create(fn)
-		if err != nil {
-			panic(fmt.Sprintf("failed to create output file '%v': %v", fn, err))
+		if *legend {
+			renderLegend(img, f, frameCnt, ehm, hhm)
 		}
-		if err := png.Encode(file, img); err != nil {
-			panic(fmt.Sprintf("failed to encode to PNG: %v", err))
+		if err := sink.Write(framesink.Frame{Index: f, Image: img}); err != nil {
+			panic(err)
 		}
-		file.Close()
-		fmt.Fprintf(os.Stdout, "generated %v\n", fn)
+		fmt.Fprintf(os.Stdout, "generated frame %04v\n", f)
+	}
+	if err := sink.Close(); err != nil {
+		panic(err)
 	}
+	finished = true
 }
 
 // Derivative of Jm at x.
@@ -293,6 +315,22 @@ func renderCaption(img draw.Image, f, frameCnt int) {
 	gc.FillStringAt(text, 40.0, 70.0)
 }
 
+// Draws the color bars of the fields shown in the frame, E on the right and H on the left, whose colors tell the
+// z coordinate along the cylinder axis.
+func renderLegend(img draw.Image, f, frameCnt int, ehm, hhm []color.Color) {
+	if f < frameCnt/3 || f >= frameCnt*2/3 {
+		if err := colorbar.Draw(img, colorbar.Options{Heatmap: ehm, Min: -d / 2, Max: d / 2, Label: "E: z"}); err != nil {
+			panic(fmt.Sprintf("failed to draw E color bar: %v", err))
+		}
+	}
+	if f >= frameCnt/3 {
+		opts := colorbar.Options{Heatmap: hhm, Min: -d / 2, Max: d / 2, Label: "H: z", Side: colorbar.Left}
+		if err := colorbar.Draw(img, opts); err != nil {
+			panic(fmt.Sprintf("failed to draw H color bar: %v", err))
+		}
+	}
+}
+
 // Make the transparency for a color based on the field strength compared to the maximum field strength.
 func makeTransparency(c color.Color, field, maxField float64) color.Color {
 	r, g, b, _ := c.RGBA()
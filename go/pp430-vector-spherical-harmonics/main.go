@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"image/color"
 	"image/draw"
-	"image/png"
 	"math"
 	"os"
 	"path/filepath"
@@ -16,6 +15,8 @@ import (
 	"github.com/euphoricrhino/go-common/graphix/zraster"
 	"github.com/llgcode/draw2d"
 	"github.com/llgcode/draw2d/draw2dimg"
+
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
 )
 
 var (
@@ -196,6 +197,7 @@ func main() {
 	sc := graphix.NewScreen(1280, 1280, -1.2, -1.2, 1.2, 1.2)
 	cir := graphix.NewCircularCameraOrbit(n, pos, forward, up, frameCnt, cameraOrbitAngleOffset, pr, sc)
 
+	sink := framesink.NewPNG(filepath.Join(*outDir, "frame-%04d.png"))
 	for f := 0; f < frameCnt; f++ {
 		img := zraster.Run(zraster.Options{
 			Camera:  cir.GetCamera(f),
@@ -203,16 +205,13 @@ func main() {
 			Workers: runtime.NumCPU(),
 		})
 		renderCaption(img)
-		fn := filepath.Join(*outDir, fmt.Sprintf("frame-%04v.png", f))
-		file, err := os.Create(fn)
-		if err != nil {
-			panic(fmt.Sprintf("failed to create output file '%v': %v", fn, err))
-		}
-		if err := png.Encode(file, img); err != nil {
-			panic(fmt.Sprintf("failed to encode to PNG: %v", err))
+		if err := sink.Write(framesink.Frame{Index: f, Image: img}); err != nil {
+			panic(err)
 		}
-		file.Close()
-		fmt.Fprintf(os.Stdout, "generated %v\n", fn)
+		fmt.Fprintf(os.Stdout, "generated frame %04v\n", f)
+	}
+	if err := sink.Close(); err != nil {
+		panic(err)
 	}
 }
 
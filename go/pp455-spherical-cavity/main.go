@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"image/color"
 	"image/draw"
-	"image/png"
 	"math"
 	"os"
 	"path/filepath"
@@ -17,6 +16,9 @@ import (
 	"github.com/euphoricrhino/go-common/graphix/zraster"
 	"github.com/llgcode/draw2d"
 	"github.com/llgcode/draw2d/draw2dimg"
+
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
 )
 
 // Example commands:
@@ -40,6 +42,8 @@ var (
 	mode = flag.String("mode", "", "has to start with TM|TE")
 
 	outDir = flag.String("out-dir", "", "output dir")
+	legend = flag.Bool("legend", false, "draw color bars of the heatmaps")
+	anim   = flag.String("animation", "", "if set, encode the frames into this .gif or .png (APNG) file instead of pngs in the output dir")
 )
 
 const (
@@ -236,6 +240,23 @@ func main() {
 
 	frameCnt := orbitPeriods * framesPerPeriod
 
+	sink := framesink.NewPNG(filepath.Join(*outDir, "frame-%04d.png"))
+	if *anim != "" {
+		// Quantize GIF frames to the colors of both heatmaps.
+		pal := append(append([]color.Color{}, ehm...), hhm...)
+		var err error
+		if sink, err = framesink.NewAnimationFile(*anim, 21.38, pal); err != nil {
+			panic(err)
+		}
+	}
+	// Rendering panics on failure, so remove an unfinished animation on the way out.
+	finished := false
+	defer func() {
+		if !finished {
+			sink.Abort()
+		}
+	}()
+
 	// Configuration for the camera orbit.
 	n := graphix.NewVec3(math.Cos(cameraOrbitAxisAngle), math.Sin(cameraOrbitAxisAngle), 0)
 	pos := graphix.NewVec3(0, 0, -camDist)
@@ -292,17 +313,18 @@ func main() {
 			Workers: runtime.NumCPU(),
 		})
 		renderCaption(img, f, frameCnt)
-		fn := filepath.Join(*outDir, fmt.Sprintf("frame-%04v.png", f))
-		file, err := os.Create(fn)
-		if err != nil {
-			panic(fmt.Sprintf("failed to create output file '%v': %v", fn, err))
+		if *legend {
+			renderLegend(img, f, frameCnt, ehm, hhm)
 		}
-		if err := png.Encode(file, img); err != nil {
-			panic(fmt.Sprintf("failed to encode to PNG: %v", err))
+		if err := sink.Write(framesink.Frame{Index: f, Image: img}); err != nil {
+			panic(err)
 		}
-		file.Close()
-		fmt.Fprintf(os.Stdout, "generated %v\n", fn)
+		fmt.Fprintf(os.Stdout, "generated frame %04v\n", f)
+	}
+	if err := sink.Close(); err != nil {
+		panic(err)
 	}
+	finished = true
 }
 
 func indices(i int) (int, int, int) {
@@ -399,6 +421,22 @@ func renderCaption(img draw.Image, f, frameCnt int) {
 	gc.FillStringAt(text, 40.0, 70.0)
 }
 
+// Draws the color bars of the fields shown in the frame, E on the right and H on the left, whose colors tell the
+// polar angle in degrees.
+func renderLegend(img draw.Image, f, frameCnt int, ehm, hhm []color.Color) {
+	if f < frameCnt/3 || f >= frameCnt*2/3 {
+		if err := colorbar.Draw(img, colorbar.Options{Heatmap: ehm, Min: 0, Max: 180, Label: "E: theta"}); err != nil {
+			panic(fmt.Sprintf("failed to draw E color bar: %v", err))
+		}
+	}
+	if f >= frameCnt/3 {
+		opts := colorbar.Options{Heatmap: hhm, Min: 0, Max: 180, Label: "H: theta", Side: colorbar.Left}
+		if err := colorbar.Draw(img, opts); err != nil {
+			panic(fmt.Sprintf("failed to draw H color bar: %v", err))
+		}
+	}
+}
+
 // Make the transparency for a color based on the field strength compared to the maximum field strength.
 func makeTransparency(c color.Color, field, maxField float64) color.Color {
 	r, g, b, _ := c.RGBA()
@@ -1,15 +1,15 @@
 package main
 
 import (
-	"encoding/binary"
 	"flag"
 	"fmt"
 	"math"
-	"os"
 	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
+
+	framesink "github.com/euphoricrhino/jackson-em-notes/go/pkg/frame-sink"
 )
 
 var (
@@ -35,23 +35,24 @@ const (
 func main() {
 	flag.Parse()
 
+	// Raw field values, rendered into images by the render program.
+	total := framesink.NewRaw(fmt.Sprintf("%v/mie-total-%%03d.data", *outDir))
+	scattered := framesink.NewRaw(fmt.Sprintf("%v/mie-scattered-%%03d.data", *outDir))
 	frame := 0
 	for rad := minRad; rad <= maxRad; rad += incRad {
 		totalField, scatteredField := computeOneFrame(rad)
-		saveFrame(totalField, fmt.Sprintf("%v/mie-total-%03v.data", *outDir, frame))
-		saveFrame(scatteredField, fmt.Sprintf("%v/mie-scattered-%03v.data", *outDir, frame))
+		if err := total.Write(framesink.Frame{Index: frame, Data: totalField}); err != nil {
+			panic(err)
+		}
+		if err := scattered.Write(framesink.Frame{Index: frame, Data: scatteredField}); err != nil {
+			panic(err)
+		}
 		frame++
 	}
-}
-
-func saveFrame(data []float64, filename string) {
-	f, err := os.Create(filename)
-	if err != nil {
-		panic(fmt.Sprintf("failed to create output file '%v': %v", filename, err))
-	}
-	defer f.Close()
-	for _, v := range data {
-		binary.Write(f, binary.LittleEndian, v)
+	for _, sink := range []framesink.FrameSink{total, scattered} {
+		if err := sink.Close(); err != nil {
+			panic(err)
+		}
 	}
 }
 
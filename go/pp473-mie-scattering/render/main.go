@@ -4,17 +4,15 @@ import (
 	"encoding/binary"
 	"flag"
 	"fmt"
-	"image"
 	"image/color"
 	"image/draw"
-	"image/png"
-	"math"
 	"os"
 
 	"github.com/llgcode/draw2d"
 	"github.com/llgcode/draw2d/draw2dimg"
 
-	"github.com/euphoricrhino/jackson-em-notes/go/pkg/heatmap"
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
+	fieldrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-renderer"
 )
 
 var (
@@ -28,41 +26,47 @@ var (
 	gamma       = flag.Float64("gamma", 1.0, "gamma correction")
 	width       = flag.Int("width", 800, "Width of the image")
 	height      = flag.Int("height", 800, "Height of the image")
+	legend      = flag.Bool("legend", false, "draw a color bar of the intensity")
+	anim        = flag.String("animation", "", "if set, encode the frames into this .gif or .png (APNG) file instead of numbered pngs")
 )
 
 // e.g.,
 // go run main.go --heatmap-file ../../heatmaps/wikipedia.png --output ./mie-scattered --data-file=../mie-scattered --count 376 --gamma=.5 --width 800 --height 800
 func main() {
 	flag.Parse()
-	hm, err := heatmap.Load(*heatmapFile, *gamma)
-	if err != nil {
-		panic(fmt.Sprintf("failed to load heatmap: %v", err))
-	}
 
-	max, min := math.NaN(), math.NaN()
 	frames := make([][]float64, *count)
 	for i := 0; i < *count; i++ {
 		frames[i] = loadData(fmt.Sprintf("%v-%03v.data", *dataFile, i))
-		for _, v := range frames[i] {
-			if math.IsNaN(max) || max < v {
-				max = v
-			}
-			if math.IsNaN(min) || min > v {
-				min = v
-			}
-		}
 	}
 
-	spread := max - min
-	for frame, data := range frames {
-		for i := range data {
-			data[i] = (data[i] - min) / spread
-		}
-		savePNG(data, hm, frame)
+	opts := fieldrenderer.Options{
+		HeatMapFile: *heatmapFile,
+		Gamma:       *gamma,
+		Width:       *width,
+		Height:      *height,
+	}
+	if *legend {
+		opts.ColorBar = &colorbar.Options{Label: "intensity"}
+	}
+	// All frames are normalized together.
+	if err := fieldrenderer.RunAnimation(fieldrenderer.Animation{
+		Options: opts,
+		Frames:  *count,
+		Field: func(frame int, x, y float64) float64 {
+			// Sampled at pixel centers, within pixel (int(x),int(y)).
+			return frames[frame][int(y)**width+int(x)]
+		},
+		OutputPattern: *output + "-%03d.png",
+		AnimationFile: *anim,
+		FrameRate:     20.75,
+		PostEdit:      postEdit,
+	}); err != nil {
+		panic(err)
 	}
 }
 
-func postEdit(img draw.Image, frame int) {
+func postEdit(frame int, img draw.Image) {
 	gc := draw2dimg.NewGraphicContext(img)
 	gc.SetLineWidth(1)
 
@@ -79,33 +83,6 @@ func postEdit(img draw.Image, frame int) {
 	gc.FillStringAt(text, 20.0, 20.0)
 }
 
-func savePNG(data []float64, hm []color.Color, frame int) {
-	img := image.NewRGBA(image.Rect(0, 0, *width, *height))
-	for x := 0; x < *width; x++ {
-		for y := 0; y < *height; y++ {
-			pixel := data[y**width+x]
-			pos := int(pixel * float64(len(hm)-1))
-			r, g, b, a := hm[pos].RGBA()
-			img.SetRGBA64(
-				x,
-				y,
-				color.RGBA64{R: uint16(r), G: uint16(g), B: uint16(b), A: uint16(a)},
-			)
-		}
-	}
-
-	postEdit(img, frame)
-	filename := fmt.Sprintf("%v-%03v.png", *output, frame)
-	out, err := os.Create(filename)
-	if err != nil {
-		panic(fmt.Sprintf("failed to create output file '%v': %v", filename, err))
-	}
-	defer out.Close()
-	if err := png.Encode(out, img); err != nil {
-		panic(fmt.Sprintf("failed to encode to PNG: %v", err))
-	}
-}
-
 func loadData(filename string) []float64 {
 	f, err := os.Open(filename)
 	if err != nil {
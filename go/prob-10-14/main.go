@@ -6,15 +6,18 @@ import (
 	"image/color"
 	"image/draw"
 	"math"
+	"os"
 
 	"github.com/llgcode/draw2d"
 	"github.com/llgcode/draw2d/draw2dimg"
 
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
 	fieldrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-renderer"
 )
 
 // Example command:
 // go run main.go --heatmap ../heatmaps/wikipedia.png --output diff --slit-width 6 --slit-height 4 --z=200 --gamma .4
+// Pass --db 40 to render the intensity in decibels, which brings out the side lobes.
 var (
 	width      = flag.Int("width", 800, "Width of the image")
 	height     = flag.Int("height", 800, "Height of the image")
@@ -24,6 +27,11 @@ var (
 	slitWidth  = flag.Float64("slit-width", 0.0, "slit width in units of lambda")
 	slitHeight = flag.Float64("slit-height", 0.0, "slit height in units of lambda")
 	z          = flag.Float64("z", 10.0, "observation point z in units of lambda")
+	db         = flag.Float64("db", 0.0, "if positive, render the intensity in decibels with this dynamic range")
+	clip       = flag.Float64("clip", 0.0, "fraction of the brightest and darkest pixels clipped, e.g., .001")
+	legend     = flag.Bool("legend", false, "draw a color bar of the intensity")
+	window     = flag.Int("window", 0, "if positive, normalize each frame with the frames this many degrees around it instead of all frames")
+	anim       = flag.String("animation", "", "if set, encode the frames into this .gif or .png (APNG) file instead of numbered pngs")
 )
 
 const (
@@ -34,31 +42,70 @@ const (
 func main() {
 	flag.Parse()
 
-	for f := 0; f <= 180; f++ {
-		saveFrame(f)
+	const frames = 181
+	fields := make([]func(float64, float64) float64, frames)
+	for f := range fields {
+		fields[f] = renderField(frameBeta(f))
 	}
-}
-
-func saveFrame(f int) {
-	beta := float64(f) * math.Pi / 180
-	if err := fieldrenderer.Run(fieldrenderer.Options{
-		HeatMapFile: *heatmap,
-		OutputFile:  fmt.Sprintf("%v-%03d.png", *output, f),
-		Gamma:       *gamma,
-		Width:       *width,
-		Height:      *height,
-		Field:       renderField(beta),
-		PostEdit:    postEdit(beta),
+	if err := fieldrenderer.RunAnimation(fieldrenderer.Animation{
+		Options: fieldrenderer.Options{
+			HeatMapFile: *heatmap,
+			Gamma:       *gamma,
+			Width:       *width,
+			Height:      *height,
+			Progress:    fieldrenderer.PrintProgress(os.Stdout),
+			// Observation screen centered on the z axis, in units of lambda.
+			Viewport: &fieldrenderer.Viewport{
+				XMin:   -screenWidthLambdas / 2,
+				XMax:   screenWidthLambdas / 2,
+				YMin:   -screenWidthLambdas / 2,
+				YMax:   screenWidthLambdas / 2,
+				Aspect: fieldrenderer.AspectStretch,
+			},
+			Normalization: normalization(),
+			ColorBar:      colorBar(),
+		},
+		Frames:        frames,
+		Field:         func(f int, x, y float64) float64 { return fields[f](x, y) },
+		OutputPattern: *output + "-%03d.png",
+		AnimationFile: *anim,
+		FrameRate:     20,
+		Window:        *window,
+		PostEdit:      func(f int, img draw.Image) { postEdit(frameBeta(f))(img) },
 	}); err != nil {
 		panic(err)
 	}
 }
 
-func renderField(beta float64) func(int, int) float64 {
-	return func(x, y int) float64 {
+// frameBeta returns the polarization angle of the frame, one degree per frame.
+func frameBeta(f int) float64 {
+	return float64(f) * math.Pi / 180
+}
+
+// normalization tames the central peak which otherwise washes out the side lobes.
+func normalization() *fieldrenderer.Normalization {
+	n := &fieldrenderer.Normalization{Clip: *clip}
+	if *db > 0.0 {
+		n.Scale = fieldrenderer.ScaleDecibels
+		n.Decibels = *db
+	}
+	return n
+}
+
+// colorBar returns the legend of the intensity, in decibels with --db.
+func colorBar() *colorbar.Options {
+	if !*legend {
+		return nil
+	}
+	if *db > 0.0 {
+		return &colorbar.Options{Label: "dB"}
+	}
+	return &colorbar.Options{Label: "intensity"}
+}
+
+func renderField(beta float64) func(float64, float64) float64 {
+	return func(fx, fy float64) float64 {
 		sbeta, cbeta := math.Sin(beta), math.Cos(beta)
-		fx := float64(x-*width/2) / float64(*width) * screenWidthLambdas
-		fy := float64(*height/2-y) / float64(*height) * screenWidthLambdas
 		rho := math.Sqrt(fx*fx + fy*fy)
 		r := math.Sqrt(fx*fx + fy*fy + *z**z)
 
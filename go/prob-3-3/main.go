@@ -4,19 +4,25 @@ import (
 	"flag"
 	"math"
 	"math/big"
+	"os"
 
+	colorbar "github.com/euphoricrhino/jackson-em-notes/go/pkg/color-bar"
 	fieldrenderer "github.com/euphoricrhino/jackson-em-notes/go/pkg/field-renderer"
 	"github.com/euphoricrhino/jackson-em-notes/go/pkg/mp"
 )
 
 var (
-	heatmap = flag.String("heatmap", "", "heatmap file")
-	output  = flag.String("output", "", "output file")
-	gamma   = flag.Float64("gamma", 1.0, "gamma correction")
-	width   = flag.Int("width", 640, "output width")
-	height  = flag.Int("height", 640, "output height")
-	terms   = flag.Int("terms", 10, "number of terms to keep in the series sum")
-	prec    = flag.Uint("prec", 100, "floating point precision")
+	heatmap  = flag.String("heatmap", "", "heatmap file")
+	output   = flag.String("output", "", "output file")
+	gamma    = flag.Float64("gamma", 1.0, "gamma correction")
+	width    = flag.Int("width", 640, "output width")
+	height   = flag.Int("height", 640, "output height")
+	contours = flag.Int("contours", 0, "number of equipotential contours to draw")
+	samples  = flag.Int("samples", 1, "supersample each pixel with samples x samples field evaluations")
+	legend   = flag.Bool("legend", false, "draw a color bar of the potential")
+	axes     = flag.Bool("axes", false, "draw axis ticks in units of the sphere radius")
+	terms    = flag.Int("terms", 10, "number of terms to keep in the series sum")
+	prec     = flag.Uint("prec", 100, "floating point precision")
 )
 
 func main() {
@@ -26,10 +32,9 @@ func main() {
 	// Construct legendre polynomials up to the 2*terms+1 order.
 	legs := constructLegendre(2*(*terms) + 1)
 
-	field := func(x, y int) float64 {
-		rad := float64(*width) / 8
-		fx := float64(x) - float64(*width-1)/2
-		fy := float64(*height-1-y) - float64(*height-1)/2
+	// Field at (fx,fy) in units of the sphere radius.
+	field := func(fx, fy float64) float64 {
+		const rad = 1.0
 
 		r := math.Sqrt(fx*fx + fy*fy)
 
@@ -83,14 +88,25 @@ func main() {
 		return fv*2.0/math.Pi + 1.0
 	}
 
-	if err := fieldrenderer.Run(fieldrenderer.Options{
+	opts := fieldrenderer.Options{
 		HeatMapFile: *heatmap,
 		OutputFile:  *output,
 		Gamma:       *gamma,
 		Width:       *width,
 		Height:      *height,
-		Field:       field,
-	}); err != nil {
+		Progress:    fieldrenderer.PrintProgress(os.Stdout),
+		WorldField:  field,
+		Viewport:    &fieldrenderer.Viewport{XMin: -4, XMax: 4, YMin: -4, YMax: 4},
+		Samples:     *samples,
+		Contours:    *contours,
+	}
+	if *axes {
+		opts.Axes = &fieldrenderer.Axes{XLabel: "x/a", YLabel: "z/a"}
+	}
+	if *legend {
+		opts.ColorBar = &colorbar.Options{Label: "potential"}
+	}
+	if err := fieldrenderer.Run(opts); err != nil {
 		panic(err)
 	}
 }